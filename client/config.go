@@ -1,9 +1,13 @@
 package client
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"maps"
+	"math"
 	"os"
-	"reflect"
+	"time"
 
 	"github.com/pelletier/go-toml/v2"
 )
@@ -12,6 +16,7 @@ type ActivityConfig struct {
 	IdleAction  string `toml:"idle_action"`
 	ViewAction  string `toml:"view_action"`
 	EditAction  string `toml:"edit_action"`
+	SaveAction  string `toml:"save_action"`
 	State       string `toml:"state"`
 	Details     string `toml:"details"`
 	LargeImage  string `toml:"large_image"`
@@ -24,11 +29,12 @@ type ActivityConfig struct {
 
 type Config struct {
 	Discord struct {
-		ApplicationID string         `toml:"application_id"`
-		SmallUse      string         `toml:"small_usage"`
-		LargeUse      string         `toml:"large_usage"`
-		RetryAfter    string         `toml:"retry_after"`
-		Activity      ActivityConfig `toml:"activity"`
+		ApplicationID  string         `toml:"application_id"`
+		SmallUse       string         `toml:"small_usage"`
+		LargeUse       string         `toml:"large_usage"`
+		RetryAfter     string         `toml:"retry_after"`
+		UpdateInterval string         `toml:"update_interval"`
+		Activity       ActivityConfig `toml:"activity"`
 	} `toml:"discord"`
 
 	Git struct {
@@ -36,9 +42,10 @@ type Config struct {
 	} `toml:"git"`
 
 	Lsp struct {
-		IdleAfter  string `toml:"idle_after"`
-		ViewAfter  string `toml:"view_after"`
-		LineOffset string `toml:"line_offset"`
+		IdleAfter   string `toml:"idle_after"`
+		ViewAfter   string `toml:"view_after"`
+		LineOffset  string `toml:"line_offset"`
+		TrackCursor bool   `toml:"track_cursor"`
 	} `toml:"lsp"`
 
 	LanguageMaps struct {
@@ -46,28 +53,41 @@ type Config struct {
 	} `toml:"language_maps"`
 
 	Logging struct {
-		Level  string `toml:"level"`
-		Output string `toml:"output"`
+		Level              string `toml:"level"`
+		Output             string `toml:"output"`
+		Format             string `toml:"format"`
+		ForwardLevel       string `toml:"forward_level"`
+		LogDocumentContent bool   `toml:"log_document_content"`
+		MaxSize            int    `toml:"max_size"`
+		RotateAfter        string `toml:"rotate_after"`
+		MaxAge             string `toml:"max_age"`
+		MaxBackups         int    `toml:"max_backups"`
+		Compress           bool   `toml:"compress"`
 	} `toml:"logging"`
+
+	Profiles map[string]Profile `toml:"profiles"`
 }
 
 func DefaultConfig() *Config {
 	return &Config{
 		Discord: struct {
-			ApplicationID string         `toml:"application_id"`
-			SmallUse      string         `toml:"small_usage"`
-			LargeUse      string         `toml:"large_usage"`
-			RetryAfter    string         `toml:"retry_after"`
-			Activity      ActivityConfig `toml:"activity"`
+			ApplicationID  string         `toml:"application_id"`
+			SmallUse       string         `toml:"small_usage"`
+			LargeUse       string         `toml:"large_usage"`
+			RetryAfter     string         `toml:"retry_after"`
+			UpdateInterval string         `toml:"update_interval"`
+			Activity       ActivityConfig `toml:"activity"`
 		}{
-			ApplicationID: "",
-			SmallUse:      "language",
-			LargeUse:      "editor",
-			RetryAfter:    "1m",
+			ApplicationID:  "",
+			SmallUse:       "language",
+			LargeUse:       "editor",
+			RetryAfter:     "1m",
+			UpdateInterval: "5s",
 			Activity: ActivityConfig{
 				IdleAction: "Idle in {editor}",
 				ViewAction: "Viewing {filename}",
 				EditAction: "Editing {filename}",
+				SaveAction: "Saved {filename}",
 
 				State:       "{action}",
 				Details:     "In {workspace}",
@@ -83,13 +103,15 @@ func DefaultConfig() *Config {
 			GitInfo bool `toml:"git_info"`
 		}{GitInfo: true},
 		Lsp: struct {
-			IdleAfter  string `toml:"idle_after"`
-			ViewAfter  string `toml:"view_after"`
-			LineOffset string `toml:"line_offset"`
+			IdleAfter   string `toml:"idle_after"`
+			ViewAfter   string `toml:"view_after"`
+			LineOffset  string `toml:"line_offset"`
+			TrackCursor bool   `toml:"track_cursor"`
 		}{
-			IdleAfter:  "5m",
-			ViewAfter:  "30s",
-			LineOffset: "+1",
+			IdleAfter:   "5m",
+			ViewAfter:   "30s",
+			LineOffset:  "+1",
+			TrackCursor: false,
 		},
 		LanguageMaps: struct {
 			URL string `toml:"url"`
@@ -97,27 +119,162 @@ func DefaultConfig() *Config {
 			URL: "https://raw.githubusercontent.com/zerootoad/discord-rich-presence-lsp/main/assets/languages.json",
 		},
 		Logging: struct {
-			Level  string `toml:"level"`
-			Output string `toml:"output"`
+			Level              string `toml:"level"`
+			Output             string `toml:"output"`
+			Format             string `toml:"format"`
+			ForwardLevel       string `toml:"forward_level"`
+			LogDocumentContent bool   `toml:"log_document_content"`
+			MaxSize            int    `toml:"max_size"`
+			RotateAfter        string `toml:"rotate_after"`
+			MaxAge             string `toml:"max_age"`
+			MaxBackups         int    `toml:"max_backups"`
+			Compress           bool   `toml:"compress"`
 		}{
-			Level:  "info",
-			Output: "file",
+			Level:              "info",
+			Output:             "file",
+			Format:             "console",
+			ForwardLevel:       "warn",
+			LogDocumentContent: false,
+			MaxSize:            10,
+			RotateAfter:        "24h",
+			MaxAge:             "168h",
+			MaxBackups:         3,
+			Compress:           false,
 		},
 	}
 }
 
-func LoadConfig(configFilePath string) (*Config, error) {
-	config := DefaultConfig()
+// LogRotation returns the log rotation settings. Invalid values disable the
+// corresponding limit.
+func (c *Config) LogRotation() LogRotation {
+	rotateAfter, _ := time.ParseDuration(c.Logging.RotateAfter)
+	maxAge, _ := time.ParseDuration(c.Logging.MaxAge)
+	return LogRotation{
+		MaxSize:     int64(max(c.Logging.MaxSize, 0)) * 1024 * 1024,
+		RotateAfter: max(rotateAfter, 0),
+		MaxAge:      max(maxAge, 0),
+		MaxBackups:  max(c.Logging.MaxBackups, 0),
+		Compress:    c.Logging.Compress,
+	}
+}
 
-	if _, err := os.Stat(configFilePath); os.IsNotExist(err) {
-		data, err := toml.Marshal(config)
-		if err != nil {
-			Error("Failed to marshal default config.", map[string]any{
-				"error": err,
-			})
-			return nil, err
+// MergeConfig returns a copy of config with overrides applied on top.
+// overrides holds config keys as nested tables, as decoded from TOML or JSON;
+// unknown keys and mismatched types are reported as a *ConfigError.
+func MergeConfig(config *Config, overrides map[string]any) (*Config, error) {
+	merged := *config
+	merged.Profiles = cloneProfiles(config.Profiles)
+	if len(overrides) == 0 {
+		return &merged, nil
+	}
+
+	data, err := toml.Marshal(normalizeNumbers(overrides))
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode config overrides: %w", err)
+	}
+
+	decoder := toml.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&merged); err != nil {
+		// The document was generated from overrides, its lines mean
+		// nothing to the user.
+		problems := decodeProblems(data, err)
+		for i := range problems {
+			problems[i].Line = 0
 		}
+		return nil, &ConfigError{Problems: problems}
+	}
+
+	return &merged, nil
+}
 
+// normalizeNumbers returns a copy of value with whole float64 numbers turned
+// into int64. JSON decodes every number as float64, which TOML refuses to
+// assign to integer keys.
+func normalizeNumbers(value any) any {
+	switch v := value.(type) {
+	case map[string]any:
+		normalized := make(map[string]any, len(v))
+		for key, item := range v {
+			normalized[key] = normalizeNumbers(item)
+		}
+		return normalized
+	case []any:
+		normalized := make([]any, len(v))
+		for i, item := range v {
+			normalized[i] = normalizeNumbers(item)
+		}
+		return normalized
+	case float64:
+		if v == math.Trunc(v) && math.Abs(v) < 1<<53 {
+			return int64(v)
+		}
+	}
+	return value
+}
+
+// cloneProfiles deep copies profiles, as decoding overrides into them
+// writes through to their activity maps.
+func cloneProfiles(profiles map[string]Profile) map[string]Profile {
+	if profiles == nil {
+		return nil
+	}
+
+	clone := make(map[string]Profile, len(profiles))
+	for name, profile := range profiles {
+		profile.Activity = maps.Clone(profile.Activity)
+		if profile.GitInfo != nil {
+			gitInfo := *profile.GitInfo
+			profile.GitInfo = &gitInfo
+		}
+		clone[name] = profile
+	}
+	return clone
+}
+
+// LoadConfigOverrides reads a partial config file, such as a per-project
+// override, as nested tables to be merged with MergeConfig. Unknown keys and
+// mismatched types are reported as a *ConfigError.
+func LoadConfigOverrides(configFilePath string) (map[string]any, error) {
+	data, err := os.ReadFile(configFilePath)
+	if err != nil {
+		return nil, err
+	}
+
+	var overrides map[string]any
+	if err := toml.Unmarshal(data, &overrides); err != nil {
+		return nil, &ConfigError{Problems: decodeProblems(data, err)}
+	}
+
+	// Keys and types are checked against the file, so problems come with
+	// their line.
+	decoder := toml.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(DefaultConfig()); err != nil {
+		return nil, &ConfigError{Problems: decodeProblems(data, err)}
+	}
+
+	return overrides, nil
+}
+
+// ReadConfig parses a config file on top of the defaults, without creating,
+// validating or upgrading it.
+func ReadConfig(configFilePath string) (*Config, error) {
+	data, err := os.ReadFile(configFilePath)
+	if err != nil {
+		return nil, err
+	}
+
+	config := DefaultConfig()
+	if err := toml.Unmarshal(data, config); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+func LoadConfig(configFilePath string) (*Config, error) {
+	if _, err := os.Stat(configFilePath); os.IsNotExist(err) {
+		data := defaultConfigTemplate
 		if err := os.WriteFile(configFilePath, data, 0644); err != nil {
 			Error("Failed to write default config file.", map[string]any{
 				"error": err,
@@ -128,83 +285,82 @@ func LoadConfig(configFilePath string) (*Config, error) {
 		Info("Created default config file.", map[string]any{
 			"filepath": configFilePath,
 		})
-	} else {
-		data, err := os.ReadFile(configFilePath)
-		if err != nil {
-			Error("Failed to read config file.", map[string]any{
-				"error": err,
-			})
-			return nil, err
-		}
+		return DefaultConfig(), nil
+	}
 
-		if err := toml.Unmarshal(data, config); err != nil {
-			Error("Failed to unmarshal config file.", map[string]any{
-				"error": err,
-			})
-			return nil, err
-		}
+	return readConfigFile(configFilePath, true)
+}
+
+// ReloadConfig reads the config file again while the server runs. Unlike
+// LoadConfig, it never writes the file, which the user may be editing.
+func ReloadConfig(configFilePath string) (*Config, error) {
+	return readConfigFile(configFilePath, false)
+}
+
+// readConfigFile parses the config file on top of the defaults and warns
+// about invalid values and unknown keys. With upgrade, the keys missing from
+// the file are added to it.
+func readConfigFile(configFilePath string, upgrade bool) (*Config, error) {
+	config := DefaultConfig()
+
+	data, err := os.ReadFile(configFilePath)
+	if err != nil {
+		Error("Failed to read config file.", map[string]any{
+			"error": err,
+		})
+		return nil, err
+	}
+
+	if err := toml.Unmarshal(data, config); err != nil {
+		Error("Failed to unmarshal config file.", map[string]any{
+			"error": err,
+		})
+		return nil, err
+	}
+
+	lines := keyLines(data)
+	for _, problem := range ValidateConfig(config) {
+		problem.Line = lines[problem.Key]
+		Warn("Invalid value in config file.", map[string]any{
+			"filepath": configFilePath,
+			"problem":  problem.String(),
+		})
+	}
+
+	if unknown := unknownKeys(data); len(unknown) > 0 {
+		Warn("Unknown keys in config file, they are ignored.", map[string]any{
+			"filepath": configFilePath,
+			"keys":     unknown,
+		})
+	}
 
-		err = fmt.Errorf("config is nil")
-		if config == nil {
-			Error("Unexpected config file.", map[string]any{
+	if !upgrade {
+		return config, nil
+	}
+
+	missing, err := missingKeys(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(missing) > 0 {
+		backupPath, err := upgradeConfigFile(configFilePath, data, config)
+		if errors.Is(err, errUnsupportedLayout) {
+			Warn("Config file layout cannot be upgraded, using defaults for missing keys.", map[string]any{
+				"filepath": configFilePath,
+				"keys":     missing,
+				"reason":   err,
+			})
+		} else if err != nil {
+			Error("Failed to upgrade config file, using defaults for missing keys.", map[string]any{
+				"keys":  missing,
 				"error": err,
 			})
-			return nil, err
-		}
-
-		defaultCfg := DefaultConfig()
-		loadedCfg := config
-
-		defaultVal := reflect.ValueOf(defaultCfg).Elem()
-		loadedVal := reflect.ValueOf(loadedCfg).Elem()
-
-		for i := range defaultVal.NumField() {
-			fieldName := defaultVal.Type().Field(i).Name
-			defaultField := defaultVal.Field(i)
-			loadedField := loadedVal.FieldByName(fieldName)
-
-			if !loadedField.IsValid() {
-				Warn("Missing field in config.", map[string]any{
-					"fieldName": fieldName,
-				})
-				return nil, fmt.Errorf("missing field: %s", fieldName)
-			}
-
-			if loadedField.Type() != defaultField.Type() {
-				Warn("Field has incorrect type.", map[string]any{
-					"fieldName":         fieldName,
-					"loadedFieldType":   loadedField.Type(),
-					"expectedFieldType": defaultField.Type(),
-				})
-				return nil, fmt.Errorf("field %s has incorrect type: expected %s, got %s", fieldName, defaultField.Type(), loadedField.Type())
-			}
-
-			if defaultField.Kind() == reflect.Struct {
-				for j := range defaultField.NumField() {
-					nestedFieldName := defaultField.Type().Field(j).Name
-					nestedDefaultField := defaultField.Field(j)
-					nestedLoadedField := loadedField.FieldByName(nestedFieldName)
-
-					if !nestedLoadedField.IsValid() {
-						Warn("Missing nested field in config.", map[string]any{
-							"parentFieldName":        fieldName,
-							"missingNestedFieldName": nestedFieldName,
-						})
-						return nil, fmt.Errorf("missing nested field: %s.%s", fieldName, nestedFieldName)
-					}
-
-					if nestedLoadedField.Type() != nestedDefaultField.Type() {
-						Warn("Nested field has incorrect type", map[string]any{
-							"parentFieldName":   fieldName,
-							"NestedFieldName":   nestedFieldName,
-							"fullFieldName":     fmt.Sprintf("%s.%s", fieldName, nestedFieldName),
-							"expectedFieldType": nestedDefaultField.Type(),
-							"loadedFieldType":   nestedLoadedField.Type(),
-						})
-						return nil, fmt.Errorf("field %s.%s has incorrect type: expected %s, got %s", fieldName, nestedFieldName, nestedDefaultField.Type(), nestedLoadedField.Type())
-					}
-				}
-			}
+		} else {
+			Info("Upgraded config file with defaults for missing keys.", map[string]any{
+				"filepath": configFilePath,
+				"backup":   backupPath,
+				"keys":     missing,
+			})
 		}
 	}
 
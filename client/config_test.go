@@ -0,0 +1,88 @@
+package client
+
+import (
+	"errors"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/pelletier/go-toml/v2"
+)
+
+func TestDefaultConfigTemplate(t *testing.T) {
+	config := &Config{}
+	if err := toml.Unmarshal(defaultConfigTemplate, config); err != nil {
+		t.Fatalf("failed to parse the default config template: %v", err)
+	}
+
+	if !reflect.DeepEqual(config, DefaultConfig()) {
+		t.Errorf("default config template does not match DefaultConfig():\ntemplate: %+v\ndefaults: %+v", config, DefaultConfig())
+	}
+}
+
+func TestMergeConfigProblems(t *testing.T) {
+	tests := []struct {
+		name      string
+		overrides map[string]any
+		want      []ConfigProblem
+	}{
+		{
+			name:      "unknown keys",
+			overrides: map[string]any{"discord": map[string]any{"foo": "x", "activity": map[string]any{"detials": "y"}}},
+			want: []ConfigProblem{
+				{Key: "discord.activity.detials", Message: "unknown key"},
+				{Key: "discord.foo", Message: "unknown key"},
+			},
+		},
+		{
+			name:      "wrong type",
+			overrides: map[string]any{"lsp": map[string]any{"idle_after": 5}},
+			want: []ConfigProblem{
+				{Key: "lsp.idle_after", Message: "must be a string, got a TOML integer"},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := MergeConfig(DefaultConfig(), test.overrides)
+
+			var configErr *ConfigError
+			if !errors.As(err, &configErr) {
+				t.Fatalf("MergeConfig error = %v, want a *ConfigError", err)
+			}
+			problems := slices.SortedFunc(slices.Values(configErr.Problems), func(a, b ConfigProblem) int {
+				return strings.Compare(a.Key, b.Key)
+			})
+			if !reflect.DeepEqual(problems, test.want) {
+				t.Errorf("problems = %v, want %v", problems, test.want)
+			}
+		})
+	}
+}
+
+func TestMergeConfigWholeNumbers(t *testing.T) {
+	merged, err := MergeConfig(DefaultConfig(), map[string]any{"logging": map[string]any{"max_size": float64(20)}})
+	if err != nil {
+		t.Fatalf("MergeConfig: %v", err)
+	}
+	if merged.Logging.MaxSize != 20 {
+		t.Errorf("max_size = %d, want 20", merged.Logging.MaxSize)
+	}
+}
+
+func TestMergeConfigKeepsProfiles(t *testing.T) {
+	config := DefaultConfig()
+	config.Profiles = map[string]Profile{"work": {Activity: map[string]any{"details": "Working"}}}
+
+	_, err := MergeConfig(config, map[string]any{
+		"profiles": map[string]any{"work": map[string]any{"activity": map[string]any{"details": "injected"}}},
+	})
+	if err != nil {
+		t.Fatalf("MergeConfig: %v", err)
+	}
+	if details := config.Profiles["work"].Activity["details"]; details != "Working" {
+		t.Errorf("base profile details = %v, want it unchanged", details)
+	}
+}
@@ -0,0 +1,195 @@
+package client
+
+import (
+	"sync"
+	"time"
+
+	"github.com/hugolgst/rich-go/client"
+)
+
+// Connection owns the Discord IPC connection. It logs in from a background
+// goroutine, retrying with exponential backoff up to retryAfter, and holds the
+// latest activity requested while disconnected so it can be sent as soon as
+// the connection comes up. When Discord goes away (restart, update, dropped
+// socket) the connection is torn down and re-established the same way, and
+// the current activity is sent again. Activities identical to the last one
+// sent are not sent again.
+type Connection struct {
+	applicationID string
+	retryAfter    time.Duration
+
+	mu         sync.Mutex
+	ipc        *ipcConn
+	connecting bool
+	closed     bool
+	pending    *client.Activity
+	lastSent   string
+	done       chan struct{}
+}
+
+func NewConnection(applicationID string, retryAfter time.Duration) *Connection {
+	return &Connection{
+		applicationID: applicationID,
+		retryAfter:    retryAfter,
+		done:          make(chan struct{}),
+	}
+}
+
+func (c *Connection) Start() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.reconnect()
+}
+
+// reconnect starts the login loop unless one is already running. c.mu must
+// be held.
+func (c *Connection) reconnect() {
+	if c.connecting || c.closed {
+		return
+	}
+	c.connecting = true
+	go c.run()
+}
+
+func (c *Connection) run() {
+	// Retries are at least a second apart, whatever retry_after says.
+	maxBackoff := max(c.retryAfter, time.Second)
+	backoff := time.Second
+	var conn *ipcConn
+	reported := false
+	for {
+		var err error
+		conn, err = openIPC(c.applicationID)
+		if err == nil {
+			break
+		}
+
+		Error("Failed to create Discord RPC client, retrying", map[string]any{
+			"error":   err,
+			"retryIn": backoff.String(),
+		})
+		if !reported {
+			reported = true
+			reportProblem("Could not connect to Discord, make sure it is running. Retrying in the background.")
+		}
+
+		select {
+		case <-c.done:
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, maxBackoff)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.connecting = false
+	if c.closed {
+		conn.Close()
+		return
+	}
+
+	c.ipc = conn
+	Info("Connected to Discord", map[string]any{
+		"applicationID": c.applicationID,
+	})
+
+	if c.pending != nil {
+		activity := *c.pending
+		c.pending = nil
+		if err := c.send(activity); err != nil {
+			Error("Failed to flush pending Discord activity", map[string]any{
+				"error": err,
+			})
+		}
+	}
+}
+
+// SetActivity sends the activity if connected, otherwise it replaces any
+// pending activity to be flushed once the connection is established.
+func (c *Connection) SetActivity(activity client.Activity) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return nil
+	}
+
+	if c.ipc == nil {
+		c.pending = &activity
+		Trace("Holding activity until Discord is connected", nil)
+		return nil
+	}
+
+	if c.isLastSent(activity) {
+		Trace("Skipped update: Discord already shows this activity", nil)
+		return nil
+	}
+
+	return c.send(activity)
+}
+
+// IsLastSent reports whether activity would display the same as the last
+// activity successfully sent to Discord.
+func (c *Connection) IsLastSent(activity client.Activity) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.isLastSent(activity)
+}
+
+// isLastSent is IsLastSent with c.mu held.
+func (c *Connection) isLastSent(activity client.Activity) bool {
+	return c.ipc != nil && c.lastSent != "" && c.lastSent == activityKey(activity)
+}
+
+// send writes the activity to the open connection. If the connection turns
+// out to be broken it is dropped, the activity is kept as pending and a
+// reconnect is started. c.mu must be held.
+func (c *Connection) send(activity client.Activity) error {
+	err := c.ipc.SetActivity(activity)
+	if err == nil {
+		c.lastSent = activityKey(activity)
+		return nil
+	}
+	if !isDisconnect(err) {
+		return err
+	}
+
+	Warn("Lost connection to Discord, reconnecting", map[string]any{
+		"error": err,
+	})
+
+	c.ipc.Close()
+	c.ipc = nil
+	c.lastSent = ""
+	c.pending = &activity
+	c.reconnect()
+
+	return nil
+}
+
+func (c *Connection) Connected() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.ipc != nil
+}
+
+func (c *Connection) Close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return
+	}
+	c.closed = true
+	close(c.done)
+
+	if c.ipc != nil {
+		c.ipc.Close()
+		c.ipc = nil
+	}
+}
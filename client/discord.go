@@ -1,23 +1,86 @@
 package client
 
 import (
+	"fmt"
+	"maps"
 	"net/http"
 	"os"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/hugolgst/rich-go/client"
 	"github.com/zerootoad/discord-rpc-lsp/utils"
 )
 
-var throttler = utils.NewThrottler(5 * time.Second)
+var (
+	// Discord allows 5 activity updates per 20 seconds.
+	scheduler = utils.NewCoalescer(5*time.Second, 5, 20*time.Second)
+	imageURLs sync.Map
 
-func Login(applicationID string) error {
-	return client.Login(applicationID)
+	// iconClient checks icon URLs. Activities are built on the presence
+	// goroutine, which a hanging request would stall.
+	iconClient = &http.Client{Timeout: 3 * time.Second}
+
+	// connection is swapped by Connect while scheduled updates read it.
+	connection atomic.Pointer[Connection]
+)
+
+// Connect starts the background Discord connection manager and returns
+// immediately. Activities set before the connection is up are held and sent
+// once it is.
+func Connect(applicationID string, retryAfter time.Duration) {
+	conn := NewConnection(applicationID, retryAfter)
+	conn.Start()
+	if old := connection.Swap(conn); old != nil {
+		old.Close()
+	}
+}
+
+// SetUpdateInterval sets the minimum time between two activity updates.
+// Updates issued in between are coalesced and only the latest one is sent.
+func SetUpdateInterval(interval time.Duration) {
+	scheduler.SetInterval(interval)
 }
 
 func Logout() {
-	client.Logout()
+	scheduler.Stop()
+	if conn := connection.Load(); conn != nil {
+		conn.Close()
+	}
+}
+
+func setActivity(activity client.Activity) error {
+	conn := connection.Load()
+	if conn == nil {
+		return fmt.Errorf("discord connection not started")
+	}
+	return conn.SetActivity(activity)
+}
+
+// scheduleActivity queues activity for the next update. If it matches what
+// Discord already shows, any pending update is dropped instead.
+func scheduleActivity(activity client.Activity) {
+	if conn := connection.Load(); conn != nil && conn.IsLastSent(activity) {
+		scheduler.Stop()
+		Trace("Skipped update: Discord already shows this activity", nil)
+		return
+	}
+
+	Trace("Queued activity update, sent once update_interval and the rate limit allow", map[string]any{
+		"state":   activity.State,
+		"details": activity.Details,
+	})
+
+	scheduler.Run(func() {
+		err := setActivity(activity)
+		if err != nil {
+			Error("Failed to update Discord activity", map[string]any{
+				"error": err,
+			})
+		}
+	})
 }
 
 func replacePlaceholders(s string, placeholders map[string]string) string {
@@ -40,25 +103,66 @@ func updateActivityConfig(config *Config, placeholders map[string]string) Activi
 	return newActivity
 }
 
+// imageRetryAfter is how long the fallback is used for an icon whose URL
+// could not be reached, before trying again.
+const imageRetryAfter = time.Minute
+
+type cachedImageURL struct {
+	url string
+	// expires is zero for answers from the server, which are kept.
+	expires time.Time
+}
+
+// getImageURL returns url if it is reachable, falling back to defaultURL.
+// Answers from the server are cached, as activities are rendered on every
+// cursor movement; network errors are retried after imageRetryAfter.
 func getImageURL(url string, defaultURL string) string {
+	key := url + "\x00" + defaultURL
+	if cached, ok := imageURLs.Load(key); ok {
+		cached := cached.(cachedImageURL)
+		if cached.expires.IsZero() || time.Now().Before(cached.expires) {
+			return cached.url
+		}
+	}
+
+	resolved, ok := resolveImageURL(url, defaultURL)
+	cached := cachedImageURL{url: resolved}
+	if !ok {
+		cached.expires = time.Now().Add(imageRetryAfter)
+	}
+	imageURLs.Store(key, cached)
+	return resolved
+}
+
+func resolveImageURL(url string, defaultURL string) (string, bool) {
 	if url == "" {
-		resp, err := http.Get(defaultURL)
-		if err != nil || resp.StatusCode != http.StatusOK {
-			return "https://raw.githubusercontent.com/zerootoad/discord-rpc-lsp/refs/heads/main/assets/icons/text.png"
+		resp, err := iconClient.Get(defaultURL)
+		if err != nil {
+			Trace("Icon unreachable, using the text icon", map[string]any{"url": defaultURL, "error": err})
+			return "https://raw.githubusercontent.com/zerootoad/discord-rpc-lsp/refs/heads/main/assets/icons/text.png", false
 		}
 		defer resp.Body.Close()
-		return defaultURL
+		if resp.StatusCode != http.StatusOK {
+			Trace("No icon found, using the text icon", map[string]any{"url": defaultURL, "status": resp.StatusCode})
+			return "https://raw.githubusercontent.com/zerootoad/discord-rpc-lsp/refs/heads/main/assets/icons/text.png", true
+		}
+		return defaultURL, true
 	}
 
-	resp, err := http.Get(url)
-	if err != nil || resp.StatusCode != http.StatusOK {
-		return defaultURL
+	resp, err := iconClient.Get(url)
+	if err != nil {
+		Trace("Configured icon unreachable, using the default one", map[string]any{"url": url, "error": err})
+		return defaultURL, false
 	}
 	defer resp.Body.Close()
-	return url
+	if resp.StatusCode != http.StatusOK {
+		Trace("Configured icon not found, using the default one", map[string]any{"url": url, "status": resp.StatusCode})
+		return defaultURL, true
+	}
+	return url, true
 }
 
-func UpdateDiscordActivity(config *Config, tempaction, filename, workspace, currentLang, editor, gitRemoteURL, gitBranchName string, timestamp *time.Time) error {
+func UpdateDiscordActivity(config *Config, tempaction, filename, workspace, currentLang, editor, gitRemoteURL, gitBranchName string, timestamp *time.Time, extra map[string]string) error {
 	if strings.Contains(workspace, os.TempDir()) {
 		workspace = editor
 	}
@@ -69,6 +173,7 @@ func UpdateDiscordActivity(config *Config, tempaction, filename, workspace, curr
 		"{editor}":    editor,
 		"{language}":  currentLang,
 	}
+	maps.Copy(placeholders, extra)
 
 	action := replacePlaceholders(tempaction, placeholders)
 	placeholders["{action}"] = action
@@ -129,25 +234,31 @@ func UpdateDiscordActivity(config *Config, tempaction, filename, workspace, curr
 		activity.Details += " (" + gitBranchName + ")"
 	}
 
-	var err error
-	throttler.Run(func() {
-		err = client.SetActivity(activity)
-		if err != nil {
-			Error("Failed to update Discord activity", map[string]any{
-				"error": err,
-			})
-		}
+	Trace("Built activity", map[string]any{
+		"state":       activity.State,
+		"details":     activity.Details,
+		"large_usage": config.Discord.LargeUse,
+		"large_image": activity.LargeImage,
+		"small_usage": config.Discord.SmallUse,
+		"small_image": activity.SmallImage,
+		"git_button":  len(activity.Buttons) > 0,
 	})
-	return err
+
+	scheduleActivity(activity)
+	return nil
 }
 
-func ClearDiscordActivity(config *Config, action, filename, workspace, editor, gitRemoteURL, gitBranchName string) error {
+// ClearDiscordActivity shows the idle activity. timestamp is when the user
+// went idle; it has to stay the same across re-renders for them to be
+// recognized as duplicates.
+func ClearDiscordActivity(config *Config, action, filename, workspace, editor, gitRemoteURL, gitBranchName string, timestamp *time.Time, extra map[string]string) error {
 	placeholders := map[string]string{
-		"{action}":    action,
 		"{filename}":  filename,
 		"{workspace}": workspace,
 		"{editor}":    editor,
 	}
+	maps.Copy(placeholders, extra)
+	placeholders["{action}"] = replacePlaceholders(action, placeholders)
 
 	tempActivity := updateActivityConfig(config, placeholders)
 
@@ -156,14 +267,13 @@ func ClearDiscordActivity(config *Config, action, filename, workspace, editor, g
 		largeImage = "https://raw.githubusercontent.com/zerootoad/discord-rpc-lsp/refs/heads/main/assets/icons/Nvemo.png"
 	}
 
-	now := time.Now()
 	activity := client.Activity{
 		State:      tempActivity.State,
 		Details:    tempActivity.Details,
 		LargeImage: largeImage,
 		LargeText:  tempActivity.LargeText,
 		Timestamps: &client.Timestamps{
-			Start: &now,
+			Start: timestamp,
 		},
 	}
 
@@ -177,14 +287,13 @@ func ClearDiscordActivity(config *Config, action, filename, workspace, editor, g
 		activity.Details += " (" + gitBranchName + ")"
 	}
 
-	var err error
-	throttler.Run(func() {
-		err = client.SetActivity(activity)
-		if err != nil {
-			Error("Failed to update Discord activity", map[string]any{
-				"error": err,
-			})
-		}
+	Trace("Built idle activity", map[string]any{
+		"state":       activity.State,
+		"details":     activity.Details,
+		"large_image": activity.LargeImage,
+		"git_button":  len(activity.Buttons) > 0,
 	})
-	return err
+
+	scheduleActivity(activity)
+	return nil
 }
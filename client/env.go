@@ -0,0 +1,142 @@
+package client
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// EnvPrefix starts the name of every environment variable overriding a
+// config key. The rest of the name is the key path in upper case with dots
+// replaced by underscores, e.g. DISCORD_RPC_LSP_DISCORD_ACTIVITY_STATE for
+// discord.activity.state.
+const EnvPrefix = "DISCORD_RPC_LSP_"
+
+// EnvVarName returns the environment variable overriding a config key.
+func EnvVarName(key string) string {
+	return EnvPrefix + strings.ToUpper(strings.ReplaceAll(key, ".", "_"))
+}
+
+// ApplyEnvOverrides returns a copy of config with the keys set through
+// environment variables applied on top, and the keys overridden. Variables
+// whose value does not fit the key's type are logged and ignored.
+func ApplyEnvOverrides(config *Config) (*Config, []string) {
+	overrides, keys, problems := envOverrides(os.LookupEnv)
+	for _, problem := range problems {
+		Warn("Invalid environment override, it is ignored.", map[string]any{
+			"problem": problem.String(),
+		})
+	}
+
+	merged, err := MergeConfig(config, overrides)
+	if err != nil {
+		Error("Failed to apply environment overrides.", map[string]any{
+			"error": err,
+		})
+		return config, nil
+	}
+
+	for _, problem := range ValidateConfig(merged) {
+		if slices.Contains(keys, problem.Key) {
+			Warn("Invalid value in environment override.", map[string]any{
+				"variable": EnvVarName(problem.Key),
+				"problem":  problem.String(),
+			})
+		}
+	}
+
+	return merged, keys
+}
+
+// LogEnvOverrides reports the keys returned by ApplyEnvOverrides at debug
+// level. It is separate so it can run once the logger uses the overridden
+// level.
+func LogEnvOverrides(keys []string) {
+	for _, key := range keys {
+		Debug("Config key overridden from environment.", map[string]any{
+			"key":      key,
+			"variable": EnvVarName(key),
+		})
+	}
+}
+
+// envOverrides looks up the environment variable of every config key and
+// returns the ones set as nested tables for MergeConfig, along with the keys
+// overridden.
+func envOverrides(lookup func(string) (string, bool)) (map[string]any, []string, []ConfigProblem) {
+	defaults, err := configTree(DefaultConfig())
+	if err != nil {
+		return nil, nil, nil
+	}
+
+	overrides := map[string]any{}
+	var keys []string
+	var problems []ConfigProblem
+	collectEnv("", defaults, overrides, lookup, &keys, &problems)
+
+	slices.Sort(keys)
+	return overrides, keys, problems
+}
+
+func collectEnv(prefix string, defaults, overrides map[string]any, lookup func(string) (string, bool), keys *[]string, problems *[]ConfigProblem) {
+	for key, value := range defaults {
+		path := key
+		if prefix != "" {
+			path = prefix + "." + key
+		}
+
+		if table, ok := value.(map[string]any); ok {
+			nested := map[string]any{}
+			collectEnv(path, table, nested, lookup, keys, problems)
+			if len(nested) > 0 {
+				overrides[key] = nested
+			}
+			continue
+		}
+
+		raw, ok := lookup(EnvVarName(path))
+		if !ok {
+			continue
+		}
+
+		parsed, err := parseEnvValue(raw, value)
+		if err != nil {
+			*problems = append(*problems, ConfigProblem{
+				Key:     EnvVarName(path),
+				Message: err.Error(),
+			})
+			continue
+		}
+
+		overrides[key] = parsed
+		*keys = append(*keys, path)
+	}
+}
+
+// parseEnvValue converts raw to the type of the key's default value.
+func parseEnvValue(raw string, value any) (any, error) {
+	switch value.(type) {
+	case bool:
+		parsed, err := strconv.ParseBool(strings.TrimSpace(raw))
+		if err != nil {
+			return nil, fmt.Errorf("must be true or false, got %q", raw)
+		}
+		return parsed, nil
+	case int64:
+		parsed, err := strconv.ParseInt(strings.TrimSpace(raw), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("must be an integer, got %q", raw)
+		}
+		return parsed, nil
+	case float64:
+		parsed, err := strconv.ParseFloat(strings.TrimSpace(raw), 64)
+		if err != nil {
+			return nil, fmt.Errorf("must be a number, got %q", raw)
+		}
+		return parsed, nil
+	default:
+		return raw, nil
+	}
+}
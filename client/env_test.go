@@ -0,0 +1,107 @@
+package client
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestEnvVarName(t *testing.T) {
+	want := "DISCORD_RPC_LSP_DISCORD_ACTIVITY_STATE"
+	if name := EnvVarName("discord.activity.state"); name != want {
+		t.Errorf("EnvVarName = %q, want %q", name, want)
+	}
+}
+
+func TestEnvOverrides(t *testing.T) {
+	tests := []struct {
+		name      string
+		env       map[string]string
+		overrides map[string]any
+		keys      []string
+		problems  []ConfigProblem
+	}{
+		{
+			name:      "none",
+			overrides: map[string]any{},
+		},
+		{
+			name: "typed values",
+			env: map[string]string{
+				"DISCORD_RPC_LSP_DISCORD_ACTIVITY_STATE":     "Hacking",
+				"DISCORD_RPC_LSP_DISCORD_ACTIVITY_TIMESTAMP": " false ",
+				"DISCORD_RPC_LSP_LOGGING_MAX_BACKUPS":        "7",
+				"DISCORD_RPC_LSP_UNKNOWN_KEY":                "ignored",
+			},
+			overrides: map[string]any{
+				"discord": map[string]any{"activity": map[string]any{"state": "Hacking", "timestamp": false}},
+				"logging": map[string]any{"max_backups": int64(7)},
+			},
+			keys: []string{"discord.activity.state", "discord.activity.timestamp", "logging.max_backups"},
+		},
+		{
+			name: "invalid values",
+			env: map[string]string{
+				"DISCORD_RPC_LSP_LOGGING_COMPRESS": "maybe",
+				"DISCORD_RPC_LSP_LSP_IDLE_AFTER":   "10m",
+			},
+			overrides: map[string]any{
+				"lsp": map[string]any{"idle_after": "10m"},
+			},
+			keys: []string{"lsp.idle_after"},
+			problems: []ConfigProblem{
+				{Key: "DISCORD_RPC_LSP_LOGGING_COMPRESS", Message: `must be true or false, got "maybe"`},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			lookup := func(name string) (string, bool) {
+				value, ok := test.env[name]
+				return value, ok
+			}
+
+			overrides, keys, problems := envOverrides(lookup)
+			if !reflect.DeepEqual(overrides, test.overrides) {
+				t.Errorf("overrides = %v, want %v", overrides, test.overrides)
+			}
+			if !reflect.DeepEqual(keys, test.keys) {
+				t.Errorf("keys = %v, want %v", keys, test.keys)
+			}
+			if !reflect.DeepEqual(problems, test.problems) {
+				t.Errorf("problems = %v, want %v", problems, test.problems)
+			}
+		})
+	}
+}
+
+func TestParseEnvValue(t *testing.T) {
+	tests := []struct {
+		raw     string
+		value   any
+		want    any
+		wantErr bool
+	}{
+		{raw: "text", value: "", want: "text"},
+		{raw: " spaced ", value: "", want: " spaced "},
+		{raw: "1", value: true, want: true},
+		{raw: "yes", value: true, wantErr: true},
+		{raw: "-3", value: int64(0), want: int64(-3)},
+		{raw: "3.5", value: int64(0), wantErr: true},
+		{raw: "0.5", value: float64(0), want: 0.5},
+		{raw: "half", value: float64(0), wantErr: true},
+	}
+
+	for _, test := range tests {
+		got, err := parseEnvValue(test.raw, test.value)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("parseEnvValue(%q, %T) = %v, want an error", test.raw, test.value, got)
+			}
+			continue
+		}
+		if err != nil || got != test.want {
+			t.Errorf("parseEnvValue(%q, %T) = %v, %v, want %v", test.raw, test.value, got, err, test.want)
+		}
+	}
+}
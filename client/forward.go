@@ -0,0 +1,110 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/rs/zerolog"
+)
+
+// LogForwarder receives the log lines at or above the forward level, e.g.
+// to send them to the editor.
+type LogForwarder func(level zerolog.Level, message string)
+
+// ProblemReporter receives problems the user has to act on, such as Discord
+// not running.
+type ProblemReporter func(message string)
+
+var forwarding struct {
+	sync.RWMutex
+	level    zerolog.Level
+	forward  LogForwarder
+	reporter ProblemReporter
+}
+
+func init() {
+	forwarding.level = zerolog.Disabled
+}
+
+// SetLogForwarder sets the function log lines are forwarded to. nil stops
+// forwarding.
+func SetLogForwarder(forward LogForwarder) {
+	forwarding.Lock()
+	defer forwarding.Unlock()
+
+	forwarding.forward = forward
+}
+
+// SetLogForwardLevel sets the minimum level of forwarded log lines: "debug",
+// "info", "warn", "error", or "off".
+func SetLogForwardLevel(level string) {
+	forwarding.Lock()
+	defer forwarding.Unlock()
+
+	switch level {
+	case "debug":
+		forwarding.level = zerolog.DebugLevel
+	case "info":
+		forwarding.level = zerolog.InfoLevel
+	case "warn":
+		forwarding.level = zerolog.WarnLevel
+	case "error":
+		forwarding.level = zerolog.ErrorLevel
+	default:
+		forwarding.level = zerolog.Disabled
+	}
+}
+
+// SetProblemReporter sets the function problems the user has to act on are
+// reported to.
+func SetProblemReporter(reporter ProblemReporter) {
+	forwarding.Lock()
+	defer forwarding.Unlock()
+
+	forwarding.reporter = reporter
+}
+
+// reportProblem tells the user about a problem they have to act on.
+func reportProblem(message string) {
+	forwarding.RLock()
+	reporter := forwarding.reporter
+	forwarding.RUnlock()
+
+	if reporter != nil {
+		reporter(message)
+	}
+}
+
+// forwardLog passes a log line to the forwarder if its level is high enough.
+func forwardLog(level zerolog.Level, msg string, fields map[string]any) {
+	forwarding.RLock()
+	forward := forwarding.forward
+	enabled := forwarding.level != zerolog.Disabled && level >= forwarding.level
+	forwarding.RUnlock()
+
+	if forward == nil || !enabled {
+		return
+	}
+
+	forward(level, strings.TrimSpace(msg+" "+formatFields(fields)))
+}
+
+// formatFields renders redacted log fields as space separated key=value
+// pairs, sorted by key.
+func formatFields(fields map[string]any) string {
+	pairs := make([]string, 0, len(fields))
+	for _, key := range slices.Sorted(maps.Keys(fields)) {
+		value := redact(fields[key])
+		if _, ok := value.(string); !ok {
+			if data, err := json.Marshal(value); err == nil {
+				value = string(data)
+			}
+		}
+		pairs = append(pairs, fmt.Sprintf("%s=%v", key, value))
+	}
+	return strings.Join(pairs, " ")
+}
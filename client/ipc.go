@@ -0,0 +1,218 @@
+package client
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/hugolgst/rich-go/client"
+)
+
+const (
+	opHandshake = 0
+	opFrame     = 1
+	opClose     = 2
+
+	ipcTimeout = 5 * time.Second
+)
+
+// dialIPC opens the Discord IPC socket. It is a variable so the transport can
+// be replaced, e.g. with a fake socket.
+var dialIPC = dialDiscordIPC
+
+type ipcConn struct {
+	conn net.Conn
+}
+
+type ipcResponse struct {
+	Cmd  string `json:"cmd"`
+	Evt  string `json:"evt"`
+	Data struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"data"`
+}
+
+// openIPC dials Discord and performs the handshake, waiting for READY.
+func openIPC(applicationID string) (*ipcConn, error) {
+	conn, err := dialIPC()
+	if err != nil {
+		return nil, err
+	}
+
+	c := &ipcConn{conn: conn}
+	payload, err := json.Marshal(client.Handshake{V: "1", ClientId: applicationID})
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+
+	op, resp, err := c.roundTrip(opHandshake, payload)
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	if op == opClose || resp.Evt != "READY" {
+		c.Close()
+		return nil, fmt.Errorf("discord handshake rejected: %s (code %d)", resp.Data.Message, resp.Data.Code)
+	}
+
+	return c, nil
+}
+
+func (c *ipcConn) SetActivity(activity client.Activity) error {
+	payload, err := json.Marshal(client.Frame{
+		Cmd: "SET_ACTIVITY",
+		Args: client.Args{
+			Pid:      os.Getpid(),
+			Activity: mapActivity(&activity),
+		},
+		Nonce: nonce(),
+	})
+	if err != nil {
+		return err
+	}
+
+	op, resp, err := c.roundTrip(opFrame, payload)
+	if err != nil {
+		return err
+	}
+	if op == opClose {
+		return fmt.Errorf("discord closed the connection: %s: %w", resp.Data.Message, io.EOF)
+	}
+	if resp.Evt == "ERROR" {
+		return fmt.Errorf("discord rejected activity: %s (code %d)", resp.Data.Message, resp.Data.Code)
+	}
+
+	return nil
+}
+
+func (c *ipcConn) Close() error {
+	return c.conn.Close()
+}
+
+func (c *ipcConn) roundTrip(op int32, payload []byte) (int32, ipcResponse, error) {
+	var resp ipcResponse
+
+	if err := c.conn.SetDeadline(time.Now().Add(ipcTimeout)); err != nil {
+		return 0, resp, err
+	}
+	if err := c.writeFrame(op, payload); err != nil {
+		return 0, resp, err
+	}
+
+	respOp, data, err := c.readFrame()
+	if err != nil {
+		return 0, resp, err
+	}
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return 0, resp, fmt.Errorf("failed to decode discord response: %w", err)
+	}
+
+	return respOp, resp, nil
+}
+
+func (c *ipcConn) writeFrame(op int32, payload []byte) error {
+	buf := make([]byte, 8+len(payload))
+	binary.LittleEndian.PutUint32(buf[0:4], uint32(op))
+	binary.LittleEndian.PutUint32(buf[4:8], uint32(len(payload)))
+	copy(buf[8:], payload)
+
+	_, err := c.conn.Write(buf)
+	return err
+}
+
+func (c *ipcConn) readFrame() (int32, []byte, error) {
+	header := make([]byte, 8)
+	if _, err := io.ReadFull(c.conn, header); err != nil {
+		return 0, nil, err
+	}
+
+	op := int32(binary.LittleEndian.Uint32(header[0:4]))
+	length := binary.LittleEndian.Uint32(header[4:8])
+
+	data := make([]byte, length)
+	if _, err := io.ReadFull(c.conn, data); err != nil {
+		return 0, nil, err
+	}
+
+	return op, data, nil
+}
+
+// isDisconnect reports whether err means the IPC connection is gone and has
+// to be re-established.
+func isDisconnect(err error) bool {
+	var netErr net.Error
+	return errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, net.ErrClosed) ||
+		errors.Is(err, syscall.EPIPE) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		(errors.As(err, &netErr) && netErr.Timeout())
+}
+
+func mapActivity(activity *client.Activity) *client.PayloadActivity {
+	payload := &client.PayloadActivity{
+		Details: activity.Details,
+		State:   activity.State,
+		Assets: client.PayloadAssets{
+			LargeImage: activity.LargeImage,
+			LargeText:  activity.LargeText,
+			SmallImage: activity.SmallImage,
+			SmallText:  activity.SmallText,
+		},
+	}
+
+	if activity.Timestamps != nil && activity.Timestamps.Start != nil {
+		start := uint64(activity.Timestamps.Start.UnixMilli())
+		payload.Timestamps = &client.PayloadTimestamps{
+			Start: &start,
+		}
+		if activity.Timestamps.End != nil {
+			end := uint64(activity.Timestamps.End.UnixMilli())
+			payload.Timestamps.End = &end
+		}
+	}
+
+	for _, button := range activity.Buttons {
+		payload.Buttons = append(payload.Buttons, &client.PayloadButton{
+			Label: button.Label,
+			Url:   button.Url,
+		})
+	}
+
+	return payload
+}
+
+// activityKey returns a comparable representation of what Discord displays
+// for activity. Hover texts are dropped when their image is not shown.
+func activityKey(activity client.Activity) string {
+	payload := mapActivity(&activity)
+	if payload.Assets.LargeImage == "" {
+		payload.Assets.LargeText = ""
+	}
+	if payload.Assets.SmallImage == "" {
+		payload.Assets.SmallText = ""
+	}
+
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+func nonce() string {
+	buf := make([]byte, 16)
+	_, _ = rand.Read(buf)
+	buf[6] = (buf[6] & 0x0f) | 0x40
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", buf[0:4], buf[4:6], buf[6:8], buf[8:10], buf[10:])
+}
@@ -0,0 +1,57 @@
+//go:build !windows
+
+package client
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ipcDirs lists the directories Discord may place its socket in, including
+// the snap and flatpak sandboxes.
+func ipcDirs() []string {
+	var bases []string
+	for _, name := range []string{"XDG_RUNTIME_DIR", "TMPDIR", "TMP", "TEMP"} {
+		if dir := os.Getenv(name); dir != "" {
+			bases = append(bases, dir)
+		}
+	}
+	bases = append(bases, "/tmp")
+
+	var dirs []string
+	for _, base := range bases {
+		dirs = append(dirs,
+			base,
+			filepath.Join(base, "snap.discord"),
+			filepath.Join(base, "app", "com.discordapp.Discord"),
+			filepath.Join(base, ".flatpak", "com.discordapp.Discord", "xdg-run"),
+		)
+	}
+	return dirs
+}
+
+func dialDiscordIPC() (net.Conn, error) {
+	var lastErr error
+	for _, dir := range ipcDirs() {
+		for i := range 10 {
+			path := filepath.Join(dir, fmt.Sprintf("discord-ipc-%d", i))
+			if _, err := os.Stat(path); err != nil {
+				continue
+			}
+
+			conn, err := net.DialTimeout("unix", path, 2*time.Second)
+			if err == nil {
+				return conn, nil
+			}
+			lastErr = err
+		}
+	}
+
+	if lastErr == nil {
+		lastErr = fmt.Errorf("discord ipc socket not found")
+	}
+	return nil, lastErr
+}
@@ -0,0 +1,264 @@
+//go:build !windows
+
+package client
+
+import (
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hugolgst/rich-go/client"
+)
+
+// fakeDiscord serves the Discord IPC protocol on a unix socket and records
+// the handshakes and activities it receives.
+type fakeDiscord struct {
+	path     string
+	listener net.Listener
+
+	handshakes chan client.Handshake
+	activities chan client.PayloadActivity
+	// dropNext makes the next SET_ACTIVITY frame be read and the connection
+	// closed without answering.
+	dropNext atomic.Bool
+	// reject makes handshakes be answered with a close frame.
+	reject atomic.Bool
+
+	mu    sync.Mutex
+	conns []net.Conn
+}
+
+func newFakeDiscord(t *testing.T) *fakeDiscord {
+	t.Helper()
+
+	// Unix socket paths are limited to about 100 bytes, shorter than some
+	// t.TempDir() paths.
+	dir, err := os.MkdirTemp("", "ipc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	path := filepath.Join(dir, "discord-ipc-0")
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f := &fakeDiscord{
+		path:       path,
+		listener:   listener,
+		handshakes: make(chan client.Handshake, 16),
+		activities: make(chan client.PayloadActivity, 16),
+	}
+	go f.accept()
+	t.Cleanup(func() {
+		listener.Close()
+		f.dropConnections()
+	})
+
+	previous := dialIPC
+	dialIPC = func() (net.Conn, error) {
+		return net.Dial("unix", f.path)
+	}
+	t.Cleanup(func() { dialIPC = previous })
+
+	return f
+}
+
+func (f *fakeDiscord) accept() {
+	for {
+		conn, err := f.listener.Accept()
+		if err != nil {
+			return
+		}
+		f.mu.Lock()
+		f.conns = append(f.conns, conn)
+		f.mu.Unlock()
+		go f.serve(conn)
+	}
+}
+
+func (f *fakeDiscord) serve(conn net.Conn) {
+	defer conn.Close()
+	ipc := &ipcConn{conn: conn}
+
+	op, data, err := ipc.readFrame()
+	if err != nil || op != opHandshake {
+		return
+	}
+	var handshake client.Handshake
+	if err := json.Unmarshal(data, &handshake); err != nil {
+		return
+	}
+	f.handshakes <- handshake
+
+	if f.reject.Load() {
+		ipc.writeFrame(opClose, []byte(`{"code":4000,"message":"Invalid Client ID"}`))
+		return
+	}
+	if err := ipc.writeFrame(opFrame, []byte(`{"cmd":"DISPATCH","evt":"READY"}`)); err != nil {
+		return
+	}
+
+	for {
+		op, data, err := ipc.readFrame()
+		if err != nil || op != opFrame {
+			return
+		}
+		if f.dropNext.CompareAndSwap(true, false) {
+			return
+		}
+
+		var frame client.Frame
+		if err := json.Unmarshal(data, &frame); err != nil || frame.Args.Activity == nil {
+			return
+		}
+		f.activities <- *frame.Args.Activity
+
+		if err := ipc.writeFrame(opFrame, []byte(`{"cmd":"SET_ACTIVITY"}`)); err != nil {
+			return
+		}
+	}
+}
+
+// dropConnections closes the open connections, as when Discord quits.
+func (f *fakeDiscord) dropConnections() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, conn := range f.conns {
+		conn.Close()
+	}
+	f.conns = nil
+}
+
+func (f *fakeDiscord) waitHandshake(t *testing.T) client.Handshake {
+	t.Helper()
+
+	select {
+	case handshake := <-f.handshakes:
+		return handshake
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for a handshake")
+		return client.Handshake{}
+	}
+}
+
+func (f *fakeDiscord) waitActivity(t *testing.T) client.PayloadActivity {
+	t.Helper()
+
+	select {
+	case activity := <-f.activities:
+		return activity
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for an activity")
+		return client.PayloadActivity{}
+	}
+}
+
+func waitFor(t *testing.T, what string, condition func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestOpenIPCHandshake(t *testing.T) {
+	f := newFakeDiscord(t)
+
+	conn, err := openIPC("1234")
+	if err != nil {
+		t.Fatalf("openIPC: %v", err)
+	}
+	defer conn.Close()
+
+	handshake := f.waitHandshake(t)
+	if handshake.V != "1" || handshake.ClientId != "1234" {
+		t.Errorf("handshake = %+v, want version 1 for client 1234", handshake)
+	}
+
+	if err := conn.SetActivity(client.Activity{State: "Editing main.go"}); err != nil {
+		t.Fatalf("SetActivity: %v", err)
+	}
+	if activity := f.waitActivity(t); activity.State != "Editing main.go" {
+		t.Errorf("state = %q, want %q", activity.State, "Editing main.go")
+	}
+}
+
+func TestOpenIPCRejected(t *testing.T) {
+	f := newFakeDiscord(t)
+	f.reject.Store(true)
+
+	if conn, err := openIPC("1234"); err == nil {
+		conn.Close()
+		t.Fatal("openIPC succeeded, want the rejected handshake to fail")
+	}
+}
+
+func TestConnectionReconnects(t *testing.T) {
+	tests := []struct {
+		name string
+		drop func(f *fakeDiscord)
+	}{
+		{
+			// The update is read but never answered: EOF.
+			name: "dropped during update",
+			drop: func(f *fakeDiscord) { f.dropNext.Store(true) },
+		},
+		{
+			// The update is written to a closed socket: EPIPE, ECONNRESET
+			// or EOF.
+			name: "dropped before update",
+			drop: func(f *fakeDiscord) { f.dropConnections() },
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f := newFakeDiscord(t)
+
+			conn := NewConnection("1234", 10*time.Millisecond)
+			conn.Start()
+			defer conn.Close()
+
+			f.waitHandshake(t)
+			waitFor(t, "the connection", conn.Connected)
+
+			first := client.Activity{State: "Viewing main.go"}
+			if err := conn.SetActivity(first); err != nil {
+				t.Fatalf("SetActivity: %v", err)
+			}
+			if activity := f.waitActivity(t); activity.State != first.State {
+				t.Fatalf("state = %q, want %q", activity.State, first.State)
+			}
+
+			test.drop(f)
+
+			second := client.Activity{State: "Editing main.go"}
+			if err := conn.SetActivity(second); err != nil {
+				t.Fatalf("SetActivity: %v", err)
+			}
+
+			// The activity lost with the connection is sent again once
+			// reconnected.
+			f.waitHandshake(t)
+			if activity := f.waitActivity(t); activity.State != second.State {
+				t.Fatalf("state after reconnect = %q, want %q", activity.State, second.State)
+			}
+			waitFor(t, "the activity to be recorded as sent", func() bool {
+				return conn.IsLastSent(second)
+			})
+		})
+	}
+}
@@ -0,0 +1,25 @@
+//go:build windows
+
+package client
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	npipe "gopkg.in/natefinch/npipe.v2"
+)
+
+func dialDiscordIPC() (net.Conn, error) {
+	var lastErr error
+	for i := range 10 {
+		// DialTimeout is required, the pipe can block for a long time when
+		// Discord is not running.
+		conn, err := npipe.DialTimeout(fmt.Sprintf(`\\.\pipe\discord-ipc-%d`, i), 2*time.Second)
+		if err == nil {
+			return conn, nil
+		}
+		lastErr = err
+	}
+	return nil, lastErr
+}
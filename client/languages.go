@@ -12,6 +12,14 @@ import (
 type LangMaps struct {
 	RegexMap map[string]string `json:"RegexMap"`
 	ExtMap   map[string]string `json:"ExtMap"`
+
+	// patterns holds the compiled keys of RegexMap.
+	patterns []languagePattern
+}
+
+type languagePattern struct {
+	re   *regexp.Regexp
+	lang string
 }
 
 func LoadLangMaps(url string) (LangMaps, error) {
@@ -31,6 +39,18 @@ func LoadLangMaps(url string) (LangMaps, error) {
 		return LangMaps{}, fmt.Errorf("error decoding JSON: %w", err)
 	}
 
+	for pattern, lang := range langMaps.RegexMap {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			Warn("Ignoring invalid language pattern", map[string]any{
+				"pattern": pattern,
+				"error":   err,
+			})
+			continue
+		}
+		langMaps.patterns = append(langMaps.patterns, languagePattern{re: re, lang: lang})
+	}
+
 	return langMaps, nil
 }
 
@@ -41,15 +61,9 @@ func (l *LangMaps) GetLanguage(fileName string) string {
 		return lang
 	}
 
-	for pattern, lang := range l.RegexMap {
-		re, err := regexp.Compile(pattern)
-		if err != nil {
-			fmt.Printf("Error compiling regex pattern '%s': %v\n", pattern, err)
-			continue
-		}
-
-		if re.MatchString(fileName) {
-			return lang
+	for _, pattern := range l.patterns {
+		if pattern.re.MatchString(fileName) {
+			return pattern.lang
 		}
 	}
 
@@ -1,97 +1,217 @@
 package client
 
 import (
+	"fmt"
 	"io"
 	"os"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/rs/zerolog"
-	"github.com/rs/zerolog/log"
+	"golang.org/x/term"
 )
 
 var (
-	currentLogFile *os.File
+	currentLogFile *rotatingFile
 	logMutex       sync.Mutex
+	stdoutReserved bool
+	logRotation    LogRotation
+	logWriter      io.Writer = os.Stderr
+	logFormat      string
+	logFields      = map[string]any{"pid": os.Getpid()}
+
+	configuredLevel = zerolog.InfoLevel
+	// traceLevel is the level requested through $/setTrace, Disabled if
+	// none. The more verbose of it and the configured level applies.
+	traceLevel = zerolog.Disabled
+
+	// logger is read by every log call without taking logMutex, and
+	// swapped whole when the configuration changes.
+	logger atomic.Pointer[zerolog.Logger]
 )
 
-func InitLogger(logfilePath string, level string, output string) {
+func init() {
+	l := newLogger()
+	logger.Store(&l)
+}
+
+// setTraceLevel changes the level requested through $/setTrace.
+func setTraceLevel(level zerolog.Level) {
+	logMutex.Lock()
+	defer logMutex.Unlock()
+
+	traceLevel = level
+	zerolog.SetGlobalLevel(min(configuredLevel, traceLevel))
+}
+
+// SetLogFields adds fields written on every log line, to tell apart the
+// logs of servers sharing a log file.
+func SetLogFields(fields map[string]any) {
 	logMutex.Lock()
 	defer logMutex.Unlock()
 
+	for k, v := range fields {
+		logFields[k] = redact(v)
+	}
+	l := newLogger()
+	logger.Store(&l)
+}
+
+// isTerminal reports whether w is a terminal.
+func isTerminal(w io.Writer) bool {
+	file, ok := w.(*os.File)
+	return ok && term.IsTerminal(int(file.Fd()))
+}
+
+// newLogger builds a logger writing to logWriter in logFormat. logMutex
+// must be held.
+func newLogger() zerolog.Logger {
+	writer := logWriter
+	if logFormat != "json" {
+		writer = zerolog.ConsoleWriter{
+			Out:        logWriter,
+			TimeFormat: time.RFC3339,
+			// No ANSI escapes in files nor in the editor's LSP output.
+			NoColor: !isTerminal(logWriter),
+		}
+	}
+
+	return zerolog.New(writer).With().
+		Timestamp().
+		Fields(logFields).
+		Logger()
+}
+
+// SetLogRotation changes how the log file is rotated, for the current and
+// the next log files.
+func SetLogRotation(rotation LogRotation) {
+	logMutex.Lock()
+	defer logMutex.Unlock()
+
+	logRotation = rotation
 	if currentLogFile != nil {
-		_ = currentLogFile.Close()
-		currentLogFile = nil
+		currentLogFile.setRotation(rotation)
 	}
+}
+
+// ReserveStdout keeps logs off stdout, which carries the JSON-RPC messages
+// when the server runs over stdio. Loggers configured for stdout write to
+// stderr instead.
+func ReserveStdout() {
+	logMutex.Lock()
+	defer logMutex.Unlock()
+
+	stdoutReserved = true
+}
+
+// InitLogger sets the level and destination of the logs. format is
+// "console" for human readable lines, or "json" for one JSON object per line.
+func InitLogger(logfilePath string, level string, output string, format string) {
+	logMutex.Lock()
+	defer logMutex.Unlock()
+
+	// The previous file is closed once the new logger is in place, as log
+	// calls running concurrently may still write to it.
+	previousLogFile := currentLogFile
+	currentLogFile = nil
+
+	// Fallbacks are reported once the new logger is in place, so they end up
+	// at the new destination.
+	var warnings []string
 
 	switch level {
 	case "debug":
-		zerolog.SetGlobalLevel(zerolog.DebugLevel)
+		configuredLevel = zerolog.DebugLevel
 	case "info":
-		zerolog.SetGlobalLevel(zerolog.InfoLevel)
+		configuredLevel = zerolog.InfoLevel
 	case "warn":
-		zerolog.SetGlobalLevel(zerolog.WarnLevel)
+		configuredLevel = zerolog.WarnLevel
 	case "error":
-		zerolog.SetGlobalLevel(zerolog.ErrorLevel)
+		configuredLevel = zerolog.ErrorLevel
 	default:
-		zerolog.SetGlobalLevel(zerolog.InfoLevel)
-		log.Warn().Msgf("Invalid logging level '%s'. Defaulting to 'info'.", level)
+		configuredLevel = zerolog.InfoLevel
+		warnings = append(warnings, fmt.Sprintf("Invalid logging level '%s'. Defaulting to 'info'.", level))
 	}
 
 	var writer io.Writer
 	switch output {
 	case "file":
-		file, err := os.OpenFile(logfilePath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+		file, err := openRotatingFile(logfilePath, logRotation)
 		if err != nil {
-			log.Fatal().Err(err).Msg("Failed to open log file")
+			writer = os.Stderr
+			warnings = append(warnings, fmt.Sprintf("Failed to open log file: %v. Defaulting to 'stderr'.", err))
+			break
 		}
 		currentLogFile = file
 		writer = file
+	case "stderr":
+		writer = os.Stderr
 	case "stdout":
+		if stdoutReserved {
+			writer = os.Stderr
+			warnings = append(warnings, "Logging to 'stdout' would corrupt the LSP stdio channel. Defaulting to 'stderr'.")
+			break
+		}
 		writer = os.Stdout
 	default:
-		writer = os.Stdout
-		log.Warn().Msgf("Invalid logging output '%s'. Defaulting to 'stdout'.", output)
+		writer = os.Stderr
+		warnings = append(warnings, fmt.Sprintf("Invalid logging output '%s'. Defaulting to 'stderr'.", output))
 	}
 
-	log.Logger = log.Output(zerolog.ConsoleWriter{
-		Out:        writer,
-		TimeFormat: time.RFC3339,
-	}).With().
-		Timestamp().
-		Logger()
+	switch format {
+	case "console", "json":
+		logFormat = format
+	default:
+		logFormat = "console"
+		warnings = append(warnings, fmt.Sprintf("Invalid logging format '%s'. Defaulting to 'console'.", format))
+	}
+
+	zerolog.SetGlobalLevel(min(configuredLevel, traceLevel))
+
+	logWriter = writer
+	l := newLogger()
+	logger.Store(&l)
+
+	if previousLogFile != nil {
+		_ = previousLogFile.Close()
+	}
+
+	for _, warning := range warnings {
+		l.Warn().Msg(warning)
+	}
 }
 
-func Info(msg string, fields map[string]any) {
-	event := log.Info()
+// writeEvent adds the redacted fields to event and writes it. Events of
+// disabled levels are skipped before redacting, which encodes every field.
+func writeEvent(event *zerolog.Event, msg string, fields map[string]any) {
+	if !event.Enabled() {
+		return
+	}
 	for k, v := range fields {
-		event.Interface(k, v)
+		event.Interface(k, redact(v))
 	}
 	event.Msg(msg)
 }
 
+func Info(msg string, fields map[string]any) {
+	writeEvent(logger.Load().Info(), msg, fields)
+	forwardLog(zerolog.InfoLevel, msg, fields)
+}
+
 func Error(msg string, fields map[string]any) {
-	event := log.Error()
-	for k, v := range fields {
-		event.Interface(k, v)
-	}
-	event.Msg(msg)
+	writeEvent(logger.Load().Error(), msg, fields)
+	forwardLog(zerolog.ErrorLevel, msg, fields)
 }
 
 func Warn(msg string, fields map[string]any) {
-	event := log.Warn()
-	for k, v := range fields {
-		event.Interface(k, v)
-	}
-	event.Msg(msg)
+	writeEvent(logger.Load().Warn(), msg, fields)
+	forwardLog(zerolog.WarnLevel, msg, fields)
 }
 
 func Debug(msg string, fields map[string]any) {
-	event := log.Debug()
-	for k, v := range fields {
-		event.Interface(k, v)
-	}
-	event.Msg(msg)
+	writeEvent(logger.Load().Debug(), msg, fields)
+	forwardLog(zerolog.DebugLevel, msg, fields)
 }
 
 func WithDuration(start time.Time, msg string, fields map[string]any) {
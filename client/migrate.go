@@ -0,0 +1,362 @@
+package client
+
+import (
+	"bytes"
+	"cmp"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/pelletier/go-toml/v2"
+)
+
+// unknownKeys returns the keys of a config file that do not exist in Config,
+// with their line numbers.
+func unknownKeys(data []byte) []string {
+	decoder := toml.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+
+	var strict *toml.StrictMissingError
+	if err := decoder.Decode(&Config{}); !errors.As(err, &strict) {
+		return nil
+	}
+
+	keys := make([]string, 0, len(strict.Errors))
+	for _, err := range strict.Errors {
+		row, _ := err.Position()
+		keys = append(keys, fmt.Sprintf("%s (line %d)", strings.Join(err.Key(), "."), row))
+	}
+	return keys
+}
+
+// missingKeys returns the keys of the default config that a config file does
+// not set.
+func missingKeys(data []byte) ([]string, error) {
+	var loaded map[string]any
+	if err := toml.Unmarshal(data, &loaded); err != nil {
+		return nil, err
+	}
+
+	defaults, err := configTree(DefaultConfig())
+	if err != nil {
+		return nil, err
+	}
+
+	var missing []string
+	collectMissing("", defaults, loaded, &missing)
+	slices.Sort(missing)
+	return missing, nil
+}
+
+func collectMissing(prefix string, defaults, loaded map[string]any, missing *[]string) {
+	for key, value := range defaults {
+		path := key
+		if prefix != "" {
+			path = prefix + "." + key
+		}
+
+		loadedValue, ok := loaded[key]
+		if !ok {
+			*missing = append(*missing, path)
+			continue
+		}
+
+		if table, ok := value.(map[string]any); ok {
+			loadedTable, _ := loadedValue.(map[string]any)
+			collectMissing(path, table, loadedTable, missing)
+		}
+	}
+}
+
+// configTree returns the config as nested tables keyed like the TOML file.
+func configTree(config *Config) (map[string]any, error) {
+	data, err := toml.Marshal(config)
+	if err != nil {
+		return nil, err
+	}
+
+	var tree map[string]any
+	if err := toml.Unmarshal(data, &tree); err != nil {
+		return nil, err
+	}
+	return tree, nil
+}
+
+// upgradeConfigFile adds the keys missing from the config file, with their
+// documentation from the template, after keeping the original next to it as
+// a timestamped backup. The rest of the file, including comments and unknown
+// keys, is left as is.
+func upgradeConfigFile(configFilePath string, original []byte, config *Config) (string, error) {
+	data, err := insertMissingKeys(original, config)
+	if err != nil {
+		return "", err
+	}
+
+	backupPath := fmt.Sprintf("%s.%s.bak", configFilePath, time.Now().Format("20060102-150405"))
+	if err := os.WriteFile(backupPath, original, 0644); err != nil {
+		return "", fmt.Errorf("failed to write config backup: %w", err)
+	}
+
+	if err := os.WriteFile(configFilePath, data, 0644); err != nil {
+		return "", err
+	}
+
+	return backupPath, nil
+}
+
+// errUnsupportedLayout is returned by insertMissingKeys for config files it
+// cannot add keys to line by line, such as ones setting tables with dotted
+// keys or inline tables.
+var errUnsupportedLayout = errors.New("config file layout not supported")
+
+// insertMissingKeys returns the config file with the keys it misses added,
+// taken from the template filled in with the values of config. Missing keys
+// go after the last key of their table, missing tables at the end of the
+// file.
+func insertMissingKeys(data []byte, config *Config) ([]byte, error) {
+	missing, err := missingKeys(data)
+	if err != nil {
+		return nil, err
+	}
+	var loaded map[string]any
+	if err := toml.Unmarshal(data, &loaded); err != nil {
+		return nil, err
+	}
+	tree, err := configTree(config)
+	if err != nil {
+		return nil, err
+	}
+	rendered, err := renderConfigTemplate(config)
+	if err != nil {
+		return nil, err
+	}
+	template := parseTemplateLines(rendered)
+
+	// Added in the order of the template, unknown keys last.
+	slices.SortStableFunc(missing, func(a, b string) int {
+		return cmp.Compare(template.index(a), template.index(b))
+	})
+
+	lines := strings.Split(strings.TrimRight(string(data), "\r\n"), "\n")
+	sections := tableSections(lines)
+
+	inserts := make(map[int][]string)
+	var appended []string
+	headers := make(map[string]bool)
+	for _, path := range missing {
+		table, key := splitKey(path)
+		if template.isTable(path) {
+			table = path
+		}
+		if err := checkHeaders(table, loaded, sections); err != nil {
+			return nil, err
+		}
+
+		if template.isTable(path) {
+			appended = append(appended, "")
+			appended = append(appended, template.table(path)...)
+			continue
+		}
+
+		block := template.key(path)
+		if block == nil {
+			value, _ := lookupKey(tree, path)
+			line, err := encodeValue(key, value)
+			if err != nil {
+				return nil, err
+			}
+			block = []string{line}
+		}
+		if len(block) > 1 {
+			block = append([]string{""}, block...)
+		}
+
+		last, ok := sections[table]
+		if !ok {
+			if !headers[table] {
+				headers[table] = true
+				appended = append(appended, "", "["+table+"]")
+				block = slices.DeleteFunc(block, func(line string) bool { return line == "" })
+			}
+			appended = append(appended, block...)
+			continue
+		}
+		inserts[last] = append(inserts[last], block...)
+	}
+
+	var out bytes.Buffer
+	write := func(lines []string) {
+		for _, line := range lines {
+			out.WriteString(line)
+			out.WriteByte('\n')
+		}
+	}
+	write(inserts[-1])
+	for i, line := range lines {
+		write([]string{line})
+		write(inserts[i])
+	}
+	write(appended)
+
+	// The file is only rewritten if it still holds the same config, with
+	// nothing left missing.
+	upgraded := DefaultConfig()
+	if err := toml.Unmarshal(out.Bytes(), upgraded); err != nil {
+		return nil, fmt.Errorf("%w: %w", errUnsupportedLayout, err)
+	}
+	if left, err := missingKeys(out.Bytes()); err != nil || len(left) > 0 || !reflect.DeepEqual(upgraded, config) {
+		return nil, fmt.Errorf("%w: the upgraded file does not hold the same config", errUnsupportedLayout)
+	}
+
+	return out.Bytes(), nil
+}
+
+// templateLines is the config template split into the blocks of lines
+// documenting and setting each table and key.
+type templateLines struct {
+	lines []string
+	// paths holds the table or key set on each line, "" for the others.
+	paths  []string
+	tables map[string]bool
+}
+
+func parseTemplateLines(data []byte) templateLines {
+	t := templateLines{tables: make(map[string]bool)}
+	table := ""
+	for line := range strings.Lines(string(data)) {
+		line = strings.TrimRight(line, "\r\n")
+		trimmed := strings.TrimSpace(line)
+
+		path := ""
+		switch {
+		case trimmed == "" || strings.HasPrefix(trimmed, "#"):
+		case strings.HasPrefix(trimmed, "["):
+			table = tomlKey(strings.Trim(trimmed, "[]"))
+			path = table
+			t.tables[table] = true
+		default:
+			if key, _, ok := strings.Cut(trimmed, "="); ok {
+				path = joinKey(table, tomlKey(key))
+			}
+		}
+
+		t.lines = append(t.lines, line)
+		t.paths = append(t.paths, path)
+	}
+	return t
+}
+
+// index returns the line of the template setting path, or the number of
+// lines if it does not set it.
+func (t templateLines) index(path string) int {
+	if i := slices.Index(t.paths, path); i >= 0 {
+		return i
+	}
+	return len(t.paths)
+}
+
+func (t templateLines) isTable(path string) bool {
+	return t.tables[path]
+}
+
+// key returns the line setting path, preceded by its comment, or nil if
+// the template does not set it.
+func (t templateLines) key(path string) []string {
+	i := slices.Index(t.paths, path)
+	if i < 0 || t.tables[path] {
+		return nil
+	}
+
+	start := i
+	for start > 0 && t.paths[start-1] == "" && strings.HasPrefix(strings.TrimSpace(t.lines[start-1]), "#") {
+		start--
+	}
+	return slices.Clone(t.lines[start : i+1])
+}
+
+// table returns the lines of the table at path and its subtables, from its
+// header to its last key.
+func (t templateLines) table(path string) []string {
+	start := slices.Index(t.paths, path)
+	end := start
+	for i := start + 1; i < len(t.paths); i++ {
+		p := t.paths[i]
+		if p == "" {
+			continue
+		}
+		if t.tables[p] && p != path && !strings.HasPrefix(p, path+".") {
+			break
+		}
+		end = i
+	}
+	return slices.Clone(t.lines[start : end+1])
+}
+
+// tableSections returns, for every table with a header in the config file
+// lines, the index of its last line holding a key or the header itself.
+// Keys before the first header belong to the root table "", whose index is
+// -1 if there are none.
+func tableSections(lines []string) map[string]int {
+	sections := map[string]int{"": -1}
+	table := ""
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case trimmed == "" || strings.HasPrefix(trimmed, "#"):
+		case strings.HasPrefix(trimmed, "[") && !strings.HasPrefix(trimmed, "[["):
+			header, _, _ := strings.Cut(trimmed[1:], "]")
+			table = tomlKey(header)
+			if _, ok := sections[table]; !ok {
+				sections[table] = i
+			}
+		default:
+			sections[table] = i
+		}
+	}
+	return sections
+}
+
+// checkHeaders returns an error if keys of table cannot be added under a
+// header of their own: table or one of its parents is set in the file
+// without a header, with dotted keys or as an inline table. Tables only
+// created by the header of a subtable are fine.
+func checkHeaders(table string, loaded map[string]any, sections map[string]int) error {
+	if table == "" {
+		return nil
+	}
+
+	parts := strings.Split(table, ".")
+	for i := range parts {
+		prefix := strings.Join(parts[:i+1], ".")
+		if _, ok := sections[prefix]; ok {
+			continue
+		}
+		if _, ok := lookupKey(loaded, prefix); !ok {
+			return nil
+		}
+		hasSubtable := false
+		for section := range sections {
+			if strings.HasPrefix(section, prefix+".") {
+				hasSubtable = true
+				break
+			}
+		}
+		if !hasSubtable {
+			return fmt.Errorf("%w: %s is set with dotted keys or an inline table", errUnsupportedLayout, prefix)
+		}
+	}
+	return nil
+}
+
+// splitKey splits a key path into its table and the key within it.
+func splitKey(path string) (string, string) {
+	i := strings.LastIndex(path, ".")
+	if i < 0 {
+		return "", path
+	}
+	return path[:i], path[i+1:]
+}
@@ -0,0 +1,88 @@
+package client
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/pelletier/go-toml/v2"
+)
+
+func TestInsertMissingKeys(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		// keep are lines that must still be in the upgraded file.
+		keep []string
+		// unsupported is set for layouts that are not upgraded.
+		unsupported bool
+	}{
+		{
+			name: "empty file",
+		},
+		{
+			name: "headed tables",
+			file: "# My config\n[discord]\nretry_after = \"2m\" # slower\nunknown = 1\n\n[discord.activity]\ndetails = \"Hacking\"\n",
+			keep: []string{"# My config", `retry_after = "2m" # slower`, "unknown = 1", `details = "Hacking"`},
+		},
+		{
+			name: "parent created by a subtable header",
+			file: "[discord.activity]\ndetails = \"Hacking\"\n",
+			keep: []string{`details = "Hacking"`},
+		},
+		{
+			name: "missing tables",
+			file: "[lsp]\nidle_after = \"10m\"\n",
+			keep: []string{`idle_after = "10m"`},
+		},
+		{
+			name:        "dotted root keys",
+			file:        "discord.retry_after = \"2m\"\n",
+			unsupported: true,
+		},
+		{
+			name:        "dotted keys in a table",
+			file:        "[discord]\nactivity.details = \"Hacking\"\n",
+			unsupported: true,
+		},
+		{
+			name:        "inline table",
+			file:        "[discord]\nactivity = { details = \"Hacking\" }\n",
+			unsupported: true,
+		},
+		{
+			name:        "root inline table",
+			file:        "lsp = { idle_after = \"10m\" }\n",
+			unsupported: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := DefaultConfig()
+			if err := toml.Unmarshal([]byte(test.file), config); err != nil {
+				t.Fatalf("invalid test file: %v", err)
+			}
+
+			upgraded, err := insertMissingKeys([]byte(test.file), config)
+			if test.unsupported {
+				if !errors.Is(err, errUnsupportedLayout) {
+					t.Fatalf("error = %v, want an unsupported layout", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("insertMissingKeys: %v", err)
+			}
+
+			if missing, err := missingKeys(upgraded); err != nil || len(missing) > 0 {
+				t.Errorf("keys still missing: %v (error %v)", missing, err)
+			}
+			for _, line := range test.keep {
+				if !strings.Contains(string(upgraded), line+"\n") {
+					t.Errorf("line %q lost from:\n%s", line, upgraded)
+				}
+			}
+		})
+	}
+}
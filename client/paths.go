@@ -0,0 +1,128 @@
+package client
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+
+	"github.com/zerootoad/discord-rpc-lsp/utils"
+)
+
+const appDirName = "discord-rpc-lsp"
+
+// ConfigEnvVar overrides the config file path.
+const ConfigEnvVar = "DISCORD_RPC_LSP_CONFIG"
+
+type Paths struct {
+	ConfigFile string
+	LogFile    string
+	// ProfileFile holds the name of the active profile.
+	ProfileFile string
+}
+
+// LegacyDir is the directory used for both config and logs before XDG
+// support, and still the default outside Linux.
+func LegacyDir() string {
+	return filepath.Join(utils.GetUserHomeDir(), "."+appDirName)
+}
+
+// ResolvePaths returns where config.toml and lsp.log live. The config file
+// is, in order of precedence, explicitConfig (the --config flag),
+// $DISCORD_RPC_LSP_CONFIG, or config.toml in the config directory. On Linux
+// that is $XDG_CONFIG_HOME/discord-rpc-lsp, with logs in
+// $XDG_STATE_HOME/discord-rpc-lsp; a config left in the legacy directory is
+// moved there, or used in place if it cannot be moved.
+func ResolvePaths(explicitConfig string) Paths {
+	configDir, stateDir := LegacyDir(), LegacyDir()
+	if usesXDG() {
+		configDir = filepath.Join(xdgDir("XDG_CONFIG_HOME", ".config"), appDirName)
+		stateDir = filepath.Join(xdgDir("XDG_STATE_HOME", filepath.Join(".local", "state")), appDirName)
+	}
+
+	paths := Paths{
+		ConfigFile:  filepath.Join(configDir, "config.toml"),
+		LogFile:     filepath.Join(stateDir, "lsp.log"),
+		ProfileFile: filepath.Join(stateDir, "profile"),
+	}
+
+	switch {
+	case explicitConfig != "":
+		paths.ConfigFile = explicitConfig
+	case os.Getenv(ConfigEnvVar) != "":
+		paths.ConfigFile = os.Getenv(ConfigEnvVar)
+	case usesXDG():
+		paths.ConfigFile = migrateLegacyConfig(filepath.Join(LegacyDir(), "config.toml"), paths.ConfigFile)
+	}
+
+	return paths
+}
+
+func usesXDG() bool {
+	return runtime.GOOS != "windows" && runtime.GOOS != "darwin"
+}
+
+func xdgDir(envVar, fallback string) string {
+	if dir := os.Getenv(envVar); filepath.IsAbs(dir) {
+		return dir
+	}
+	return filepath.Join(utils.GetUserHomeDir(), fallback)
+}
+
+// migrateLegacyConfig moves the legacy config file to target unless target
+// already exists. It returns the config file to use.
+func migrateLegacyConfig(legacy, target string) string {
+	if _, err := os.Stat(target); err == nil {
+		return target
+	}
+	if _, err := os.Stat(legacy); err != nil {
+		return target
+	}
+
+	if err := moveFile(legacy, target); err != nil {
+		Warn("Failed to move legacy config, using it in place", map[string]any{
+			"from":  legacy,
+			"to":    target,
+			"error": err,
+		})
+		return legacy
+	}
+
+	Info("Moved legacy config", map[string]any{
+		"from": legacy,
+		"to":   target,
+	})
+	return target
+}
+
+func moveFile(from, to string) error {
+	if err := os.MkdirAll(filepath.Dir(to), 0755); err != nil {
+		return err
+	}
+	if err := os.Rename(from, to); err == nil {
+		return nil
+	}
+
+	// Rename fails across filesystems, fall back to copying.
+	src, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(to, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		os.Remove(to)
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		os.Remove(to)
+		return err
+	}
+
+	return os.Remove(from)
+}
@@ -0,0 +1,128 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// DefaultProfile selects the base config, without any profile applied. It
+// cannot be used as a profile name.
+const DefaultProfile = "default"
+
+// Profile is a named set of activity and privacy settings, defined under
+// [profiles.<name>] in config.toml. While it is active, the keys it sets
+// replace the ones of [discord.activity] and git.git_info; the keys it leaves
+// out keep their value.
+type Profile struct {
+	Activity map[string]any `toml:"activity"`
+	GitInfo  *bool          `toml:"git_info"`
+}
+
+// overrides returns the profile as config keys to be merged with MergeConfig.
+func (p Profile) overrides() map[string]any {
+	overrides := map[string]any{}
+	if len(p.Activity) > 0 {
+		overrides["discord"] = map[string]any{"activity": p.Activity}
+	}
+	if p.GitInfo != nil {
+		overrides["git"] = map[string]any{"git_info": *p.GitInfo}
+	}
+	return overrides
+}
+
+// ProfileNames returns the names of the profiles defined in config, sorted.
+func (c *Config) ProfileNames() []string {
+	return slices.Sorted(maps.Keys(c.Profiles))
+}
+
+// HasProfile reports whether name can be selected: either a defined profile
+// or DefaultProfile. An empty name is DefaultProfile.
+func (c *Config) HasProfile(name string) bool {
+	if name == "" || name == DefaultProfile {
+		return true
+	}
+	_, ok := c.Profiles[name]
+	return ok
+}
+
+// ProfileOverrides returns the keys set by the named profile, to be merged on
+// top of config with MergeConfig. It returns nil for DefaultProfile and
+// unknown profiles.
+func (c *Config) ProfileOverrides(name string) map[string]any {
+	profile, ok := c.Profiles[name]
+	if !ok || name == DefaultProfile {
+		return nil
+	}
+	return profile.overrides()
+}
+
+// validateProfile checks the keys set by a profile.
+func validateProfile(config *Config, name string) []ConfigProblem {
+	prefix := "profiles." + name
+	if name == DefaultProfile {
+		return []ConfigProblem{{
+			Key:     prefix,
+			Message: fmt.Sprintf("%q is reserved for the config without profile", DefaultProfile),
+		}}
+	}
+
+	merged, err := MergeConfig(config, config.ProfileOverrides(name))
+	if err != nil {
+		var configErr *ConfigError
+		if !errors.As(err, &configErr) {
+			return []ConfigProblem{{Key: prefix, Message: err.Error()}}
+		}
+
+		// Report the keys as written in the profile.
+		problems := configErr.Problems
+		for i, problem := range problems {
+			if rest, ok := strings.CutPrefix(problem.Key, "discord.activity."); ok {
+				problems[i].Key = prefix + ".activity." + rest
+			} else if rest, ok := strings.CutPrefix(problem.Key, "git."); ok {
+				problems[i].Key = prefix + "." + rest
+			}
+		}
+		return problems
+	}
+
+	var problems []ConfigProblem
+	for _, problem := range validateActivity(prefix+".activity", merged.Discord.Activity) {
+		if _, ok := config.Profiles[name].Activity[strings.TrimPrefix(problem.Key, prefix+".activity.")]; ok {
+			problems = append(problems, problem)
+		}
+	}
+	return problems
+}
+
+// LoadActiveProfile returns the profile stored in the state file, or
+// DefaultProfile if there is none.
+func LoadActiveProfile(stateFilePath string) string {
+	data, err := os.ReadFile(stateFilePath)
+	if err != nil {
+		return DefaultProfile
+	}
+
+	name := strings.TrimSpace(string(data))
+	if name == "" {
+		return DefaultProfile
+	}
+	return name
+}
+
+// SaveActiveProfile stores the active profile in the state file, so it is
+// kept across restarts and picked up by the other running servers.
+func SaveActiveProfile(stateFilePath, name string) error {
+	if name == "" {
+		name = DefaultProfile
+	}
+
+	if err := os.MkdirAll(filepath.Dir(stateFilePath), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(stateFilePath, []byte(name+"\n"), 0644)
+}
@@ -0,0 +1,360 @@
+package client
+
+import (
+	"cmp"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LogRotation configures when the log file is rotated and which rotated
+// files are kept. Zero values disable the corresponding limit.
+type LogRotation struct {
+	// MaxSize is the size in bytes above which the log file is rotated.
+	MaxSize int64
+	// RotateAfter is the age after which the log file is rotated.
+	RotateAfter time.Duration
+	// MaxAge is how long rotated files are kept.
+	MaxAge time.Duration
+	// MaxBackups is how many rotated files are kept.
+	MaxBackups int
+	// Compress gzips rotated files.
+	Compress bool
+}
+
+const (
+	// rotateCheckInterval is how often a writer checks whether another
+	// process rotated the file under it.
+	rotateCheckInterval = time.Second
+	// staleLockAge is the age after which a rotation lock left behind by a
+	// crashed process is ignored.
+	staleLockAge = 10 * time.Second
+	// backupTimeFormat is the timestamp suffix of rotated files.
+	backupTimeFormat = "20060102-150405"
+)
+
+// rotatingFile appends to a log file and rotates it once it grows past
+// MaxSize or gets older than RotateAfter, renaming it with a timestamp
+// suffix. Several processes can share the same path: rotation is serialized
+// through a lock file, and writers reopen the path when another process
+// rotated the file they hold.
+type rotatingFile struct {
+	path string
+
+	mu       sync.Mutex
+	rotation LogRotation
+	file     *os.File
+	checked  time.Time
+	// started is when the open file was started, i.e. the last rotation.
+	started time.Time
+}
+
+func openRotatingFile(path string, rotation LogRotation) (*rotatingFile, error) {
+	r := &rotatingFile{path: path, rotation: rotation}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	go r.cleanup()
+	return r, nil
+}
+
+func (r *rotatingFile) open() error {
+	file, err := os.OpenFile(r.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+	if err != nil {
+		return err
+	}
+	r.file = file
+	r.checked = time.Now()
+	r.started = r.startTime()
+	return nil
+}
+
+// startTime returns when the log file was started. That is the time of the
+// last rotation, found in the name of the newest rotated file, as the file
+// may be shared with other processes. A file that was never rotated counts
+// from when it was opened.
+func (r *rotatingFile) startTime() time.Time {
+	now := time.Now()
+	if info, err := r.file.Stat(); err != nil || info.Size() == 0 {
+		return now
+	}
+
+	backups, err := r.backups()
+	if err != nil || len(backups) == 0 {
+		return now
+	}
+
+	started := backups[len(backups)-1].rotated
+	if started.After(now) {
+		return now
+	}
+	return started
+}
+
+func (r *rotatingFile) setRotation(rotation LogRotation) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.rotation = rotation
+}
+
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.file == nil {
+		return 0, os.ErrClosed
+	}
+
+	if time.Since(r.checked) >= rotateCheckInterval {
+		r.checked = time.Now()
+		if r.moved() {
+			r.reopen()
+		}
+	}
+
+	if r.rotation.MaxSize > 0 || r.rotation.RotateAfter > 0 {
+		if info, err := r.file.Stat(); err == nil && info.Size() > 0 {
+			tooLarge := r.rotation.MaxSize > 0 && info.Size()+int64(len(p)) > r.rotation.MaxSize
+			tooOld := r.rotation.RotateAfter > 0 && time.Since(r.started) > r.rotation.RotateAfter
+			if tooLarge || tooOld {
+				r.rotate()
+			}
+		}
+	}
+
+	return r.file.Write(p)
+}
+
+func (r *rotatingFile) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.file == nil {
+		return nil
+	}
+	err := r.file.Close()
+	r.file = nil
+	return err
+}
+
+// moved reports whether the path no longer refers to the open file.
+func (r *rotatingFile) moved() bool {
+	current, err := r.file.Stat()
+	if err != nil {
+		return true
+	}
+	onDisk, err := os.Stat(r.path)
+	return err != nil || !os.SameFile(current, onDisk)
+}
+
+// reopen switches to the file currently at the path. On failure the old
+// file is kept, so nothing is lost.
+func (r *rotatingFile) reopen() {
+	old := r.file
+	if err := r.open(); err != nil {
+		r.file = old
+		return
+	}
+	old.Close()
+}
+
+// rotate renames the log file and starts a new one. If another process
+// holds the rotation lock, or already rotated the file, it only reopens.
+// Writing goes on to the old file if rotation fails.
+func (r *rotatingFile) rotate() {
+	unlock, err := lockFile(r.path + ".lock")
+	if err != nil {
+		return
+	}
+	defer unlock()
+
+	if r.moved() {
+		r.reopen()
+		return
+	}
+
+	if err := os.Rename(r.path, backupName(r.path)); err != nil {
+		return
+	}
+	r.reopen()
+
+	go r.cleanup()
+}
+
+// backupName returns an unused name for a rotated log file.
+func backupName(path string) string {
+	name := fmt.Sprintf("%s.%s", path, time.Now().Format(backupTimeFormat))
+	candidate := name
+	for i := 1; ; i++ {
+		_, err := os.Stat(candidate)
+		_, gzErr := os.Stat(candidate + ".gz")
+		if os.IsNotExist(err) && os.IsNotExist(gzErr) {
+			return candidate
+		}
+		candidate = fmt.Sprintf("%s.%d", name, i)
+	}
+}
+
+// cleanup compresses rotated files if enabled, then removes the ones
+// exceeding MaxBackups or older than MaxAge.
+func (r *rotatingFile) cleanup() {
+	r.mu.Lock()
+	rotation := r.rotation
+	r.mu.Unlock()
+
+	backups, err := r.backups()
+	if err != nil {
+		return
+	}
+
+	if rotation.Compress {
+		for i, backup := range backups {
+			if strings.HasSuffix(backup.name, ".gz") {
+				continue
+			}
+			if err := compressFile(backup.name); err == nil {
+				backups[i].name = backup.name + ".gz"
+			}
+		}
+	}
+
+	// Newest first.
+	slices.Reverse(backups)
+	for i, backup := range backups {
+		remove := rotation.MaxBackups > 0 && i >= rotation.MaxBackups
+		if !remove && rotation.MaxAge > 0 {
+			info, err := os.Stat(backup.name)
+			remove = err == nil && time.Since(info.ModTime()) > rotation.MaxAge
+		}
+		if remove {
+			os.Remove(backup.name)
+		}
+	}
+}
+
+// backup is a rotated log file.
+type backup struct {
+	name string
+	// rotated is when the file was rotated, from the timestamp in its name.
+	rotated time.Time
+	// seq tells apart files rotated within the same second: 0 for the first,
+	// then the numeric suffix added by backupName.
+	seq int
+}
+
+// backups returns the rotated files of the log, oldest first. Files whose
+// name was not given by backupName are left out.
+func (r *rotatingFile) backups() ([]backup, error) {
+	matches, err := filepath.Glob(r.path + ".*")
+	if err != nil {
+		return nil, err
+	}
+
+	backups := make([]backup, 0, len(matches))
+	for _, match := range matches {
+		if backup, ok := parseBackup(r.path, match); ok {
+			backups = append(backups, backup)
+		}
+	}
+
+	// Names do not sort by age: "x.9" sorts after "x.10" and "x" after
+	// "x.1".
+	slices.SortFunc(backups, func(a, b backup) int {
+		return cmp.Or(a.rotated.Compare(b.rotated), cmp.Compare(a.seq, b.seq))
+	})
+	return backups, nil
+}
+
+// parseBackup parses the name of a rotated file of the log at path:
+// path.<timestamp>[.<seq>][.gz].
+func parseBackup(path, name string) (backup, bool) {
+	suffix, ok := strings.CutPrefix(name, path+".")
+	if !ok {
+		return backup{}, false
+	}
+	suffix = strings.TrimSuffix(suffix, ".gz")
+
+	stamp, seq, hasSeq := strings.Cut(suffix, ".")
+	rotated, err := time.ParseInLocation(backupTimeFormat, stamp, time.Local)
+	if err != nil {
+		return backup{}, false
+	}
+
+	b := backup{name: name, rotated: rotated}
+	if hasSeq {
+		b.seq, err = strconv.Atoi(seq)
+		if err != nil || b.seq < 1 {
+			return backup{}, false
+		}
+	}
+	return b, true
+}
+
+// compressFile gzips path into path.gz and removes path. A concurrent
+// compression of the same file by another process makes it fail.
+func compressFile(path string) error {
+	tmp := path + ".gz.tmp"
+	dst, err := os.OpenFile(tmp, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+
+	err = func() error {
+		src, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer src.Close()
+
+		gz := gzip.NewWriter(dst)
+		if _, err := io.Copy(gz, src); err != nil {
+			return err
+		}
+		if err := gz.Close(); err != nil {
+			return err
+		}
+		return dst.Close()
+	}()
+	if err != nil {
+		dst.Close()
+		os.Remove(tmp)
+		return err
+	}
+
+	if err := os.Rename(tmp, path+".gz"); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Remove(path)
+}
+
+// lockFile takes an exclusive lock shared between processes by creating
+// path, waiting briefly if it is held. The returned function releases it.
+func lockFile(path string) (func(), error) {
+	for range 20 {
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if err == nil {
+			file.Close()
+			return func() { os.Remove(path) }, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return nil, err
+		}
+
+		if info, err := os.Stat(path); err == nil && time.Since(info.ModTime()) > staleLockAge {
+			os.Remove(path)
+			continue
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	return nil, fmt.Errorf("timed out waiting for lock %s", path)
+}
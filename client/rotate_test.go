@@ -0,0 +1,179 @@
+package client
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// listBackups returns the names of the rotated files of the log at path,
+// oldest first.
+func listBackups(t *testing.T, path string) []string {
+	t.Helper()
+
+	r := &rotatingFile{path: path}
+	backups, err := r.backups()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	for _, backup := range backups {
+		names = append(names, filepath.Base(backup.name))
+	}
+	return names
+}
+
+func TestRotateOnSize(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "lsp.log")
+	r, err := openRotatingFile(path, LogRotation{MaxSize: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	for _, line := range []string{"12345\n", "67890\n"} {
+		if _, err := r.Write([]byte(line)); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+	}
+
+	backups := listBackups(t, path)
+	if len(backups) != 1 {
+		t.Fatalf("backups = %v, want one", backups)
+	}
+	if data, _ := os.ReadFile(filepath.Join(filepath.Dir(path), backups[0])); string(data) != "12345\n" {
+		t.Errorf("backup = %q, want %q", data, "12345\n")
+	}
+	if data, _ := os.ReadFile(path); string(data) != "67890\n" {
+		t.Errorf("log = %q, want %q", data, "67890\n")
+	}
+}
+
+func TestRotateAfter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "lsp.log")
+	r, err := openRotatingFile(path, LogRotation{RotateAfter: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	if _, err := r.Write([]byte("first\n")); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if backups := listBackups(t, path); len(backups) != 0 {
+		t.Fatalf("rotated before rotate_after: %v", backups)
+	}
+
+	r.mu.Lock()
+	r.started = time.Now().Add(-2 * time.Hour)
+	r.mu.Unlock()
+
+	if _, err := r.Write([]byte("second\n")); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if backups := listBackups(t, path); len(backups) != 1 {
+		t.Fatalf("backups = %v, want one after rotate_after", backups)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "second\n" {
+		t.Errorf("log = %q, want %q", data, "second\n")
+	}
+}
+
+func TestBackupsOrder(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "lsp.log")
+
+	// Same second rotations past .9, and files that are not backups.
+	names := []string{
+		"lsp.log.20250102-030405.10",
+		"lsp.log.20250102-030405",
+		"lsp.log.20250102-030405.9.gz",
+		"lsp.log.20250102-030405.1",
+		"lsp.log.20250101-000000.gz",
+		"lsp.log.lock",
+		"lsp.log.20250102-030405.gz.tmp",
+		"lsp.log.old",
+	}
+	for _, name := range names {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := []string{
+		"lsp.log.20250101-000000.gz",
+		"lsp.log.20250102-030405",
+		"lsp.log.20250102-030405.1",
+		"lsp.log.20250102-030405.9.gz",
+		"lsp.log.20250102-030405.10",
+	}
+	if got := listBackups(t, path); !slices.Equal(got, want) {
+		t.Errorf("backups = %v, want %v", got, want)
+	}
+}
+
+func TestCleanupRetention(t *testing.T) {
+	tests := []struct {
+		name     string
+		rotation LogRotation
+		// old are backups modified long ago.
+		old  []string
+		want []string
+	}{
+		{
+			name:     "max backups",
+			rotation: LogRotation{MaxBackups: 2},
+			want:     []string{"lsp.log.20250102-030405.9", "lsp.log.20250102-030405.10"},
+		},
+		{
+			name:     "max age",
+			rotation: LogRotation{MaxAge: time.Hour},
+			old:      []string{"lsp.log.20250102-030405", "lsp.log.20250102-030405.1"},
+			want:     []string{"lsp.log.20250102-030405.9", "lsp.log.20250102-030405.10"},
+		},
+		{
+			name:     "unlimited",
+			rotation: LogRotation{},
+			want: []string{
+				"lsp.log.20250102-030405",
+				"lsp.log.20250102-030405.1",
+				"lsp.log.20250102-030405.9",
+				"lsp.log.20250102-030405.10",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, "lsp.log")
+
+			for _, name := range []string{
+				"lsp.log.20250102-030405",
+				"lsp.log.20250102-030405.1",
+				"lsp.log.20250102-030405.9",
+				"lsp.log.20250102-030405.10",
+			} {
+				if err := os.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for _, name := range test.old {
+				old := time.Now().Add(-2 * time.Hour)
+				if err := os.Chtimes(filepath.Join(dir, name), old, old); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			r := &rotatingFile{path: path, rotation: test.rotation}
+			r.cleanup()
+
+			if got := listBackups(t, path); !slices.Equal(got, test.want) {
+				t.Errorf("backups = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
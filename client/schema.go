@@ -0,0 +1,191 @@
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+)
+
+// durationPattern matches the durations accepted by time.ParseDuration,
+// without sign.
+const durationPattern = `^([0-9]+(\.[0-9]*)?(ns|us|µs|ms|s|m|h))+$`
+
+// schemaConstraints holds the value constraints of config keys, keyed by
+// TOML path. Profile keys use the constraints of the key they replace.
+var schemaConstraints = map[string]map[string]any{
+	"discord.application_id":       {"pattern": `^(\d+)?$`},
+	"discord.small_usage":          {"enum": usageValues},
+	"discord.large_usage":          {"enum": usageValues},
+	"discord.retry_after":          {"pattern": durationPattern},
+	"discord.update_interval":      {"pattern": durationPattern},
+	"discord.activity.large_image": {"pattern": `^(https?://.+)?$`},
+	"discord.activity.small_image": {"pattern": `^(https?://.+)?$`},
+	"lsp.idle_after":               {"pattern": durationPattern},
+	"lsp.view_after":               {"pattern": durationPattern},
+	"lsp.line_offset":              {"pattern": lineOffsetPattern.String()},
+	"language_maps.url":            {"format": "uri", "pattern": `^https?://`},
+	"logging.level":                {"enum": logLevelValues},
+	"logging.output":               {"enum": logOutputValues},
+	"logging.format":               {"enum": logFormatValues},
+	"logging.forward_level":        {"enum": forwardLevelValues},
+	"logging.max_size":             {"minimum": 0},
+	"logging.rotate_after":         {"pattern": durationPattern},
+	"logging.max_age":              {"pattern": durationPattern},
+	"logging.max_backups":          {"minimum": 0},
+	"profiles":                     {"description": "Named profiles, switched with `discord-rpc-lsp profile <name>` or the discord-rpc-lsp.profile command."},
+	"profiles.*.activity":          {"description": "Keys of [discord.activity] replaced while the profile is active."},
+	"profiles.*.git_info":          {"description": "Replaces git.git_info while the profile is active."},
+}
+
+// schemaTypes overrides the Go type used to describe a config key. Profile
+// activities are decoded loosely so that unset keys can be told apart, but
+// take the keys of ActivityConfig.
+var schemaTypes = map[string]reflect.Type{
+	"profiles.*.activity": reflect.TypeFor[ActivityConfig](),
+}
+
+// ConfigSchema returns a JSON Schema describing config.toml, for editors
+// validating and completing TOML files. Descriptions come from the comments
+// of the default config template.
+func ConfigSchema() ([]byte, error) {
+	defaults, err := configTree(DefaultConfig())
+	if err != nil {
+		return nil, err
+	}
+
+	generator := schemaGenerator{
+		descriptions: templateDescriptions(),
+		defaults:     defaults,
+	}
+
+	schema := generator.schema("", reflect.TypeFor[Config](), true)
+	schema["$schema"] = "http://json-schema.org/draft-07/schema#"
+	schema["title"] = "discord-rpc-lsp config"
+
+	return json.MarshalIndent(schema, "", "  ")
+}
+
+type schemaGenerator struct {
+	descriptions map[string]string
+	defaults     map[string]any
+}
+
+// schema describes values of type t found at path. Defaults are only given
+// for keys of the base config, not for profiles.
+func (g schemaGenerator) schema(path string, t reflect.Type, withDefaults bool) map[string]any {
+	if override, ok := schemaTypes[path]; ok {
+		t = override
+	}
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	schema := map[string]any{}
+	switch t.Kind() {
+	case reflect.Struct:
+		properties := map[string]any{}
+		for i := range t.NumField() {
+			field := t.Field(i)
+			key, _, _ := strings.Cut(field.Tag.Get("toml"), ",")
+			if key == "" || key == "-" {
+				continue
+			}
+			properties[key] = g.schema(joinKey(path, key), field.Type, withDefaults)
+		}
+		schema["type"] = "object"
+		schema["properties"] = properties
+		schema["additionalProperties"] = false
+
+	case reflect.Map:
+		schema["type"] = "object"
+		schema["additionalProperties"] = g.schema(joinKey(path, "*"), t.Elem(), false)
+
+	case reflect.String:
+		schema["type"] = "string"
+	case reflect.Bool:
+		schema["type"] = "boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		schema["type"] = "integer"
+	case reflect.Float32, reflect.Float64:
+		schema["type"] = "number"
+	}
+
+	// Profile keys replace base keys and share their documentation.
+	base := path
+	if rest, ok := strings.CutPrefix(path, "profiles.*."); ok {
+		base = rest
+		if rest, ok := strings.CutPrefix(rest, "activity."); ok {
+			base = "discord.activity." + rest
+		}
+	}
+
+	if description, ok := g.descriptions[base]; ok {
+		schema["description"] = description
+	}
+	for _, key := range []string{base, path} {
+		for name, value := range schemaConstraints[key] {
+			schema[name] = value
+		}
+	}
+	if withDefaults && t.Kind() != reflect.Struct && t.Kind() != reflect.Map {
+		if value, ok := lookupKey(g.defaults, path); ok {
+			schema["default"] = value
+		}
+	}
+
+	return schema
+}
+
+func joinKey(prefix, key string) string {
+	if prefix == "" {
+		return key
+	}
+	return prefix + "." + key
+}
+
+// templateDescriptions returns the comment block written above each key of
+// the default config template, keyed by TOML path. Keys following another
+// key without a comment of their own share its description.
+func templateDescriptions() map[string]string {
+	descriptions := make(map[string]string)
+	table := ""
+	var comment []string
+	last := ""
+
+	scanner := bufio.NewScanner(bytes.NewReader(defaultConfigTemplate))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "":
+			comment = nil
+			last = ""
+		case strings.HasPrefix(line, "#"):
+			comment = append(comment, strings.TrimSpace(strings.TrimPrefix(line, "#")))
+			last = ""
+		case strings.HasPrefix(line, "["):
+			table = tomlKey(strings.Trim(line, "[]"))
+			comment = nil
+			last = ""
+		default:
+			key, _, ok := strings.Cut(line, "=")
+			if !ok {
+				continue
+			}
+			path := joinKey(table, tomlKey(key))
+
+			description := strings.TrimSpace(strings.Join(comment, "\n"))
+			if description == "" && last != "" {
+				description = descriptions[last]
+			}
+			if description != "" {
+				descriptions[path] = description
+			}
+			comment = nil
+			last = path
+		}
+	}
+
+	return descriptions
+}
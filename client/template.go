@@ -0,0 +1,100 @@
+package client
+
+import (
+	"bufio"
+	"bytes"
+	_ "embed"
+	"strings"
+
+	"github.com/pelletier/go-toml/v2"
+)
+
+// defaultConfigTemplate is the commented config written when none exists.
+// Its values must stay equal to DefaultConfig().
+//
+//go:embed config.default.toml
+var defaultConfigTemplate []byte
+
+// renderConfigTemplate returns the commented config template with the values
+// of config filled in, so rewritten files keep their documentation.
+func renderConfigTemplate(config *Config) ([]byte, error) {
+	tree, err := configTree(config)
+	if err != nil {
+		return nil, err
+	}
+
+	var out bytes.Buffer
+	table := ""
+
+	scanner := bufio.NewScanner(bytes.NewReader(defaultConfigTemplate))
+	for scanner.Scan() {
+		line := scanner.Text()
+		trimmed := strings.TrimSpace(line)
+
+		switch {
+		case trimmed == "" || strings.HasPrefix(trimmed, "#"):
+		case strings.HasPrefix(trimmed, "["):
+			table = tomlKey(strings.Trim(trimmed, "[]"))
+		default:
+			key, _, ok := strings.Cut(trimmed, "=")
+			if !ok {
+				break
+			}
+			key = tomlKey(key)
+
+			value, ok := lookupKey(tree, table+"."+key)
+			if !ok {
+				break
+			}
+			encoded, err := encodeValue(key, value)
+			if err != nil {
+				return nil, err
+			}
+			line = encoded
+		}
+
+		out.WriteString(line)
+		out.WriteByte('\n')
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	// Profiles have user-defined names, so they cannot be part of the
+	// template and are written after it.
+	if profiles, ok := tree["profiles"]; ok {
+		data, err := toml.Marshal(map[string]any{"profiles": profiles})
+		if err != nil {
+			return nil, err
+		}
+		out.WriteByte('\n')
+		out.Write(data)
+	}
+
+	return out.Bytes(), nil
+}
+
+func lookupKey(tree map[string]any, path string) (any, bool) {
+	parts := strings.Split(strings.TrimPrefix(path, "."), ".")
+	var value any = tree
+	for _, part := range parts {
+		table, ok := value.(map[string]any)
+		if !ok {
+			return nil, false
+		}
+		value, ok = table[part]
+		if !ok {
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+// encodeValue returns the TOML line setting key to value.
+func encodeValue(key string, value any) (string, error) {
+	data, err := toml.Marshal(map[string]any{key: value})
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
@@ -0,0 +1,59 @@
+package client
+
+import (
+	"sync"
+
+	"github.com/rs/zerolog"
+)
+
+// Tracer receives the presence decisions traced while the trace value is
+// "verbose", e.g. to send them as $/logTrace to the editor.
+type Tracer func(message, verbose string)
+
+var tracing struct {
+	sync.RWMutex
+	verbose bool
+	tracer  Tracer
+}
+
+// SetTracer sets the function traces are sent to. nil stops tracing.
+func SetTracer(tracer Tracer) {
+	tracing.Lock()
+	defer tracing.Unlock()
+
+	tracing.tracer = tracer
+}
+
+// SetTrace applies the LSP trace value: "messages" raises the log level to
+// at least info and "verbose" to debug, and also enables Trace. "off"
+// restores the configured log level.
+func SetTrace(value string) {
+	tracing.Lock()
+	tracing.verbose = value == "verbose"
+	tracing.Unlock()
+
+	switch value {
+	case "message", "messages":
+		setTraceLevel(zerolog.InfoLevel)
+	case "verbose":
+		setTraceLevel(zerolog.DebugLevel)
+	default:
+		setTraceLevel(zerolog.Disabled)
+	}
+}
+
+// Trace describes a presence decision, such as the template or icon used or
+// why an update was skipped, to the editor when the trace value is
+// "verbose". It is also logged at debug level.
+func Trace(message string, details map[string]any) {
+	Debug(message, details)
+
+	tracing.RLock()
+	tracer := tracing.tracer
+	verbose := tracing.verbose
+	tracing.RUnlock()
+
+	if tracer != nil && verbose {
+		tracer(message, formatFields(details))
+	}
+}
@@ -0,0 +1,303 @@
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/pelletier/go-toml/v2"
+)
+
+// Placeholders lists the placeholders available in activity templates.
+var Placeholders = []string{
+	"{action}",
+	"{filename}",
+	"{workspace}",
+	"{editor}",
+	"{language}",
+	"{open_files}",
+	"{line}",
+	"{column}",
+	"{saves}",
+	"{file_saves}",
+}
+
+// Valid values of the enumerated config keys.
+var (
+	usageValues        = []string{"language", "editor"}
+	logLevelValues     = []string{"debug", "info", "warn", "error"}
+	logOutputValues    = []string{"file", "stderr", "stdout"}
+	logFormatValues    = []string{"console", "json"}
+	forwardLevelValues = []string{"off", "debug", "info", "warn", "error"}
+)
+
+var (
+	placeholderPattern = regexp.MustCompile(`\{[a-z_]+\}`)
+	lineOffsetPattern  = regexp.MustCompile(`^\s*[+-]\s*\d+\s*$`)
+	applicationPattern = regexp.MustCompile(`^\d+$`)
+)
+
+// ConfigProblem is a single invalid value in a config file.
+type ConfigProblem struct {
+	Key     string
+	Line    int
+	Message string
+}
+
+func (p ConfigProblem) String() string {
+	if p.Line > 0 {
+		return fmt.Sprintf("%s (line %d): %s", p.Key, p.Line, p.Message)
+	}
+	return fmt.Sprintf("%s: %s", p.Key, p.Message)
+}
+
+// ConfigError holds every problem found in a config.
+type ConfigError struct {
+	Problems []ConfigProblem
+}
+
+func (e *ConfigError) Error() string {
+	messages := make([]string, len(e.Problems))
+	for i, problem := range e.Problems {
+		messages[i] = problem.String()
+	}
+	return fmt.Sprintf("invalid config: %s", strings.Join(messages, "; "))
+}
+
+// ValidateConfig checks the values of config and returns every problem
+// found. Line numbers are left empty.
+func ValidateConfig(config *Config) []ConfigProblem {
+	var problems []ConfigProblem
+	add := func(key, format string, args ...any) {
+		problems = append(problems, ConfigProblem{Key: key, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if id := config.Discord.ApplicationID; id != "" && !applicationPattern.MatchString(id) {
+		add("discord.application_id", "must be empty or a numeric Discord application ID, got %q", id)
+	}
+	for key, value := range map[string]string{
+		"discord.small_usage": config.Discord.SmallUse,
+		"discord.large_usage": config.Discord.LargeUse,
+	} {
+		if !slices.Contains(usageValues, value) {
+			add(key, `must be "language" or "editor", got %q`, value)
+		}
+	}
+	for key, value := range map[string]string{
+		"discord.retry_after":     config.Discord.RetryAfter,
+		"discord.update_interval": config.Discord.UpdateInterval,
+		"lsp.idle_after":          config.Lsp.IdleAfter,
+		"lsp.view_after":          config.Lsp.ViewAfter,
+	} {
+		duration, err := time.ParseDuration(value)
+		if err != nil {
+			add(key, `must be a duration such as "30s" or "5m", got %q`, value)
+		} else if duration <= 0 {
+			add(key, "must be greater than zero, got %q", value)
+		}
+	}
+	if duration, err := time.ParseDuration(config.Logging.RotateAfter); err != nil {
+		add("logging.rotate_after", `must be a duration such as "24h", or "0s" to only rotate by size, got %q`, config.Logging.RotateAfter)
+	} else if duration < 0 {
+		add("logging.rotate_after", "must not be negative, got %q", config.Logging.RotateAfter)
+	}
+	if duration, err := time.ParseDuration(config.Logging.MaxAge); err != nil {
+		add("logging.max_age", `must be a duration such as "168h", or "0s" to keep rotated logs forever, got %q`, config.Logging.MaxAge)
+	} else if duration < 0 {
+		add("logging.max_age", "must not be negative, got %q", config.Logging.MaxAge)
+	}
+	for key, value := range map[string]int{
+		"logging.max_size":    config.Logging.MaxSize,
+		"logging.max_backups": config.Logging.MaxBackups,
+	} {
+		if value < 0 {
+			add(key, "must not be negative, got %d", value)
+		}
+	}
+	if !lineOffsetPattern.MatchString(config.Lsp.LineOffset) {
+		add("lsp.line_offset", `must be "+" or "-" followed by a number (e.g., "+1", "- 2"), got %q`, config.Lsp.LineOffset)
+	}
+	if !isURL(config.LanguageMaps.URL) {
+		add("language_maps.url", "must be an http(s) URL, got %q", config.LanguageMaps.URL)
+	}
+	if !slices.Contains(logLevelValues, config.Logging.Level) {
+		add("logging.level", `must be one of "debug", "info", "warn", "error", got %q`, config.Logging.Level)
+	}
+	if !slices.Contains(logOutputValues, config.Logging.Output) {
+		add("logging.output", `must be "file", "stderr" or "stdout", got %q`, config.Logging.Output)
+	}
+	if !slices.Contains(logFormatValues, config.Logging.Format) {
+		add("logging.format", `must be "console" or "json", got %q`, config.Logging.Format)
+	}
+	if !slices.Contains(forwardLevelValues, config.Logging.ForwardLevel) {
+		add("logging.forward_level", `must be one of "off", "debug", "info", "warn", "error", got %q`, config.Logging.ForwardLevel)
+	}
+
+	problems = append(problems, validateActivity("discord.activity", config.Discord.Activity)...)
+	for _, name := range config.ProfileNames() {
+		problems = append(problems, validateProfile(config, name)...)
+	}
+
+	slices.SortFunc(problems, func(a, b ConfigProblem) int {
+		return strings.Compare(a.Key, b.Key)
+	})
+	return problems
+}
+
+func validateActivity(prefix string, activity ActivityConfig) []ConfigProblem {
+	var problems []ConfigProblem
+
+	templates := map[string]string{
+		"idle_action": activity.IdleAction,
+		"view_action": activity.ViewAction,
+		"edit_action": activity.EditAction,
+		"save_action": activity.SaveAction,
+		"state":       activity.State,
+		"details":     activity.Details,
+		"large_image": activity.LargeImage,
+		"large_text":  activity.LargeText,
+		"small_image": activity.SmallImage,
+		"small_text":  activity.SmallText,
+	}
+	for key, template := range templates {
+		for _, placeholder := range placeholderPattern.FindAllString(template, -1) {
+			if !slices.Contains(Placeholders, placeholder) {
+				problems = append(problems, ConfigProblem{
+					Key:     prefix + "." + key,
+					Message: fmt.Sprintf("unknown placeholder %s", placeholder),
+				})
+			}
+		}
+	}
+
+	for key, image := range map[string]string{
+		"large_image": activity.LargeImage,
+		"small_image": activity.SmallImage,
+	} {
+		if image != "" && !isURL(placeholderPattern.ReplaceAllString(image, "x")) {
+			problems = append(problems, ConfigProblem{
+				Key:     prefix + "." + key,
+				Message: fmt.Sprintf("must be empty or an http(s) URL to an image, got %q", image),
+			})
+		}
+	}
+
+	return problems
+}
+
+func isURL(value string) bool {
+	parsed, err := url.Parse(value)
+	return err == nil && (parsed.Scheme == "http" || parsed.Scheme == "https") && parsed.Host != ""
+}
+
+// CheckConfigFile parses and validates a config file, returning every
+// problem found with its line number. Unknown keys are not problems, they
+// are only reported when loading.
+func CheckConfigFile(configFilePath string) ([]ConfigProblem, error) {
+	data, err := os.ReadFile(configFilePath)
+	if err != nil {
+		return nil, err
+	}
+
+	config := DefaultConfig()
+	if err := toml.Unmarshal(data, config); err != nil {
+		return decodeProblems(data, err), nil
+	}
+
+	problems := ValidateConfig(config)
+	lines := keyLines(data)
+	for i := range problems {
+		problems[i].Line = lines[problems[i].Key]
+	}
+
+	return problems, nil
+}
+
+// typeErrorPattern matches the go-toml errors for values of the wrong type,
+// which name the Go field instead of the key.
+var typeErrorPattern = regexp.MustCompile(`cannot decode TOML (\w+) into .* of type (\S+)$`)
+
+// decodeProblems turns an error decoding the TOML document data into config
+// problems: one per unknown key for strict decoding, or the key and line of
+// a syntax or type error. Lines are the ones of data.
+func decodeProblems(data []byte, err error) []ConfigProblem {
+	var strictErr *toml.StrictMissingError
+	if errors.As(err, &strictErr) {
+		problems := make([]ConfigProblem, 0, len(strictErr.Errors))
+		for _, keyErr := range strictErr.Errors {
+			line, _ := keyErr.Position()
+			problems = append(problems, ConfigProblem{
+				Key:     strings.Join(keyErr.Key(), "."),
+				Line:    line,
+				Message: "unknown key",
+			})
+		}
+		return problems
+	}
+
+	problem := ConfigProblem{Key: "(file)", Message: err.Error()}
+	var decodeErr *toml.DecodeError
+	if errors.As(err, &decodeErr) {
+		problem.Line, _ = decodeErr.Position()
+		if key := decodeErr.Key(); len(key) > 0 {
+			problem.Key = strings.Join(key, ".")
+		}
+	}
+	if match := typeErrorPattern.FindStringSubmatch(err.Error()); match != nil {
+		problem.Message = fmt.Sprintf("must be a %s, got a TOML %s", match[2], match[1])
+		for key, line := range keyLines(data) {
+			if line == problem.Line {
+				problem.Key = key
+			}
+		}
+	}
+	return []ConfigProblem{problem}
+}
+
+// keyLines maps the dotted key paths of a TOML document to the line they are
+// set on.
+func keyLines(data []byte) map[string]int {
+	lines := make(map[string]int)
+	table := ""
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for number := 1; scanner.Scan(); number++ {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "" || strings.HasPrefix(line, "#"):
+		case strings.HasPrefix(line, "[[") && strings.HasSuffix(line, "]]"):
+			table = tomlKey(line[2 : len(line)-2])
+		case strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]"):
+			table = tomlKey(line[1 : len(line)-1])
+			lines[table] = number
+		default:
+			key, _, ok := strings.Cut(line, "=")
+			if !ok {
+				continue
+			}
+			path := tomlKey(key)
+			if table != "" {
+				path = table + "." + path
+			}
+			lines[path] = number
+		}
+	}
+
+	return lines
+}
+
+// tomlKey normalizes a possibly quoted, dotted TOML key.
+func tomlKey(key string) string {
+	parts := strings.Split(key, ".")
+	for i, part := range parts {
+		parts[i] = strings.Trim(strings.TrimSpace(part), `"'`)
+	}
+	return strings.Join(parts, ".")
+}
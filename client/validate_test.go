@@ -0,0 +1,125 @@
+package client
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestValidateConfig(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(config *Config)
+		// want are the keys with problems.
+		want []string
+	}{
+		{
+			name:   "defaults",
+			modify: func(config *Config) {},
+		},
+		{
+			name: "durations",
+			modify: func(config *Config) {
+				config.Discord.RetryAfter = "0s"
+				config.Lsp.IdleAfter = "soon"
+				config.Logging.RotateAfter = "0s"
+				config.Logging.MaxAge = "-1h"
+			},
+			want: []string{"discord.retry_after", "logging.max_age", "lsp.idle_after"},
+		},
+		{
+			name: "enums",
+			modify: func(config *Config) {
+				config.Discord.SmallUse = "image"
+				config.Logging.Level = "trace"
+				config.Logging.Output = "syslog"
+				config.Logging.ForwardLevel = "off"
+			},
+			want: []string{"discord.small_usage", "logging.level", "logging.output"},
+		},
+		{
+			name: "activity",
+			modify: func(config *Config) {
+				config.Discord.Activity.Details = "In {workspace} ({open_files} files)"
+				config.Discord.Activity.State = "{filenmae}"
+				config.Discord.Activity.LargeImage = "https://example.com/{language}.png"
+				config.Discord.Activity.SmallImage = "icon.png"
+			},
+			want: []string{"discord.activity.small_image", "discord.activity.state"},
+		},
+		{
+			name: "numbers and urls",
+			modify: func(config *Config) {
+				config.Discord.ApplicationID = "my-app"
+				config.Logging.MaxBackups = -1
+				config.Lsp.LineOffset = "one"
+				config.LanguageMaps.URL = "ftp://example.com/maps.json"
+			},
+			want: []string{"discord.application_id", "language_maps.url", "logging.max_backups", "lsp.line_offset"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := DefaultConfig()
+			test.modify(config)
+
+			var keys []string
+			for _, problem := range ValidateConfig(config) {
+				keys = append(keys, problem.Key)
+			}
+			if !reflect.DeepEqual(keys, test.want) {
+				t.Errorf("problems for keys %v, want %v", keys, test.want)
+			}
+		})
+	}
+}
+
+func TestCheckConfigFile(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		want []ConfigProblem
+	}{
+		{
+			name: "valid",
+			file: "[discord]\nretry_after = \"2m\"\n",
+		},
+		{
+			name: "invalid value",
+			file: "[discord]\nretry_after = \"2m\"\n\n[lsp]\nidle_after = \"soon\"\n",
+			want: []ConfigProblem{
+				{Key: "lsp.idle_after", Line: 5, Message: `must be a duration such as "30s" or "5m", got "soon"`},
+			},
+		},
+		{
+			name: "wrong type",
+			file: "[lsp]\nview_after = \"1m\"\nidle_after = 5\n",
+			want: []ConfigProblem{
+				{Key: "lsp.idle_after", Line: 3, Message: "must be a string, got a TOML integer"},
+			},
+		},
+		{
+			name: "unknown keys are not problems",
+			file: "[discord]\ndetials = \"x\"\n",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config.toml")
+			if err := os.WriteFile(path, []byte(test.file), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			problems, err := CheckConfigFile(path)
+			if err != nil {
+				t.Fatalf("CheckConfigFile: %v", err)
+			}
+			if !reflect.DeepEqual(problems, test.want) {
+				t.Errorf("problems = %v, want %v", problems, test.want)
+			}
+		})
+	}
+}
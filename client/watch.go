@@ -0,0 +1,37 @@
+package client
+
+import (
+	"os"
+	"time"
+)
+
+// WatchConfig calls reload whenever the config file changes on disk. The
+// file is polled every interval, which also catches editors that save by
+// renaming a new file over the old one. It blocks until done is closed.
+func WatchConfig(configFilePath string, interval time.Duration, done <-chan struct{}, reload func()) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	last, _ := os.Stat(configFilePath)
+	for {
+		select {
+		case <-done:
+			return
+
+		case <-ticker.C:
+			info, err := os.Stat(configFilePath)
+			if err != nil {
+				continue
+			}
+			if last != nil && info.ModTime().Equal(last.ModTime()) && info.Size() == last.Size() {
+				continue
+			}
+			last = info
+
+			Info("Config file changed, reloading", map[string]any{
+				"filepath": configFilePath,
+			})
+			reload()
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package handler
+
+import (
+	"fmt"
+
+	"github.com/tliron/glsp"
+	protocol "github.com/tliron/glsp/protocol_3_16"
+	"github.com/zerootoad/discord-rpc-lsp/client"
+)
+
+// ProfileCommand switches the presence profile. Given a profile name as
+// argument it activates it, and remembers it across restarts. It returns the
+// active profile and the profiles available.
+const ProfileCommand = "discord-rpc-lsp.profile"
+
+func (h *LSPHandler) executeCommand(ctx *glsp.Context, params *protocol.ExecuteCommandParams) (any, error) {
+	switch params.Command {
+	case ProfileCommand:
+		if len(params.Arguments) > 0 {
+			name, ok := params.Arguments[0].(string)
+			if !ok {
+				return nil, fmt.Errorf("%s expects a profile name, got %T", ProfileCommand, params.Arguments[0])
+			}
+			if err := h.switchProfile(name); err != nil {
+				h.showMessage(protocol.MessageTypeError, fmt.Sprintf("%s: %s", h.Name, err))
+				return nil, err
+			}
+			h.showMessage(protocol.MessageTypeInfo, fmt.Sprintf("%s: switched to profile %s", h.Name, h.ActiveProfile()))
+		}
+
+		return map[string]any{
+			"active":   h.ActiveProfile(),
+			"profiles": h.Config().ProfileNames(),
+		}, nil
+
+	default:
+		return nil, fmt.Errorf("unknown command %q", params.Command)
+	}
+}
+
+// switchProfile activates a profile and stores it, so it is kept across
+// restarts and picked up by the other running servers.
+func (h *LSPHandler) switchProfile(name string) error {
+	if err := h.SetProfile(name); err != nil {
+		return err
+	}
+
+	if h.ProfileFile != "" {
+		if err := client.SaveActiveProfile(h.ProfileFile, h.ActiveProfile()); err != nil {
+			client.Error("Failed to save active profile", map[string]any{
+				"filepath": h.ProfileFile,
+				"error":    err,
+			})
+		}
+	}
+
+	return nil
+}
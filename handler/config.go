@@ -0,0 +1,340 @@
+package handler
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/zerootoad/discord-rpc-lsp/client"
+)
+
+// ProjectConfigFile is the per-project override file looked up in the
+// workspace root.
+const ProjectConfigFile = ".discord-rpc-lsp.toml"
+
+// Config returns the effective configuration: config.toml, with the active
+// profile, the project file, the initializationOptions and then the editor
+// settings merged on top.
+func (h *LSPHandler) Config() *client.Config {
+	return h.config.Load()
+}
+
+// SetFileConfig replaces the configuration loaded from config.toml, e.g.
+// after it was reloaded, and rebuilds the effective configuration. Override
+// layers that no longer apply on top of it are dropped.
+func (h *LSPHandler) SetFileConfig(config *client.Config) {
+	h.configMutex.Lock()
+	defer h.configMutex.Unlock()
+
+	if !config.HasProfile(h.profile) {
+		client.Warn("Active profile is no longer defined, using the config without profile", map[string]any{
+			"profile": h.profile,
+		})
+	}
+
+	merged, err := mergeLayers(config, config.ProfileOverrides(h.profile), h.projectSettings, h.initOptions, h.editorSettings)
+	if err != nil {
+		client.Error("Dropping config overrides that no longer apply", map[string]any{
+			"error": err,
+		})
+		h.projectSettings = nil
+		h.initOptions = nil
+		h.editorSettings = nil
+		merged, err = mergeLayers(config, config.ProfileOverrides(h.profile))
+		if err != nil {
+			merged = config
+		}
+	}
+
+	h.fileConfig = config
+	h.config.Store(merged)
+	h.applyConfig(merged)
+}
+
+// setProjectSettings replaces the overrides read from the project file and
+// rebuilds the effective configuration. Invalid overrides are rejected as a
+// whole.
+func (h *LSPHandler) setProjectSettings(overrides map[string]any) error {
+	if err := checkOverrideKeys(overrides); err != nil {
+		return err
+	}
+
+	h.configMutex.Lock()
+	defer h.configMutex.Unlock()
+
+	config, err := mergeLayers(h.fileConfig, h.fileConfig.ProfileOverrides(h.profile), overrides, h.initOptions, h.editorSettings)
+	if err != nil {
+		return err
+	}
+
+	h.projectSettings = overrides
+	h.config.Store(config)
+	h.applyConfig(config)
+
+	return nil
+}
+
+// setInitOptions replaces the settings sent by the editor through
+// initializationOptions and rebuilds the effective configuration. Invalid
+// settings are rejected as a whole.
+func (h *LSPHandler) setInitOptions(settings any) error {
+	return h.setEditorLayer(&h.initOptions, "initializationOptions", settings)
+}
+
+// setEditorSettings replaces the settings sent by the editor through
+// workspace/didChangeConfiguration, which apply on top of the
+// initializationOptions, and rebuilds the effective configuration. Invalid
+// settings are rejected as a whole.
+func (h *LSPHandler) setEditorSettings(settings any) error {
+	return h.setEditorLayer(&h.editorSettings, "editor settings", settings)
+}
+
+// setEditorLayer replaces one of the editor layers, h.initOptions or
+// h.editorSettings.
+func (h *LSPHandler) setEditorLayer(layer *map[string]any, source string, settings any) error {
+	overrides, err := editorOverrides(settings, h.Name)
+	if err != nil {
+		return err
+	}
+	if err := checkOverrideKeys(overrides); err != nil {
+		return err
+	}
+
+	h.configMutex.Lock()
+	defer h.configMutex.Unlock()
+
+	previous := *layer
+	*layer = overrides
+	config, err := mergeLayers(h.fileConfig, h.fileConfig.ProfileOverrides(h.profile), h.projectSettings, h.initOptions, h.editorSettings)
+	if err != nil {
+		*layer = previous
+		return err
+	}
+
+	h.config.Store(config)
+	h.applyConfig(config)
+
+	client.Info("Applied "+source, map[string]any{
+		"settings": overrides,
+	})
+
+	return nil
+}
+
+// ActiveProfile returns the name of the active profile.
+func (h *LSPHandler) ActiveProfile() string {
+	h.configMutex.Lock()
+	defer h.configMutex.Unlock()
+
+	return h.profile
+}
+
+// SetProfile activates the named profile of config.toml, or none for
+// client.DefaultProfile, and rebuilds the effective configuration.
+func (h *LSPHandler) SetProfile(name string) error {
+	if name == "" {
+		name = client.DefaultProfile
+	}
+
+	h.configMutex.Lock()
+	defer h.configMutex.Unlock()
+
+	if !h.fileConfig.HasProfile(name) {
+		return fmt.Errorf("unknown profile %q, available: %s", name, strings.Join(append([]string{client.DefaultProfile}, h.fileConfig.ProfileNames()...), ", "))
+	}
+
+	config, err := mergeLayers(h.fileConfig, h.fileConfig.ProfileOverrides(name), h.projectSettings, h.initOptions, h.editorSettings)
+	if err != nil {
+		return err
+	}
+
+	changed := name != h.profile
+	h.profile = name
+	h.config.Store(config)
+	h.applyConfig(config)
+
+	if changed {
+		client.Info("Switched profile", map[string]any{
+			"profile": name,
+		})
+	}
+
+	return nil
+}
+
+// loadProjectConfig reads the project file from the workspace root, if any,
+// and keeps watching it for changes.
+func (h *LSPHandler) loadProjectConfig(rootPath string) {
+	path := filepath.Join(rootPath, ProjectConfigFile)
+	if _, err := os.Stat(path); err != nil {
+		return
+	}
+
+	load := func() {
+		overrides, err := client.LoadConfigOverrides(path)
+		if err == nil {
+			err = h.setProjectSettings(overrides)
+		}
+		if err != nil {
+			client.Error("Ignoring invalid project config", map[string]any{
+				"filepath": path,
+				"error":    err,
+			})
+			h.reportConfigError(path, err)
+			return
+		}
+
+		client.Info("Applied project config", map[string]any{
+			"filepath": path,
+		})
+	}
+
+	load()
+	go client.WatchConfig(path, 2*time.Second, nil, load)
+}
+
+// mergeLayers applies the override layers on top of config, lowest
+// precedence first. The overrides must not introduce invalid values.
+func mergeLayers(base *client.Config, layers ...map[string]any) (*client.Config, error) {
+	config := base
+	for _, overrides := range layers {
+		var err error
+		config, err = client.MergeConfig(config, overrides)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	known := make(map[client.ConfigProblem]bool)
+	for _, problem := range client.ValidateConfig(base) {
+		known[problem] = true
+	}
+
+	var problems []client.ConfigProblem
+	for _, problem := range client.ValidateConfig(config) {
+		if !known[problem] {
+			problems = append(problems, problem)
+		}
+	}
+	if len(problems) > 0 {
+		return nil, &client.ConfigError{Problems: problems}
+	}
+	return config, nil
+}
+
+// fileOnlyTables are the config tables only read from config.toml and the
+// environment. They set up the process as a whole, such as the log file
+// shared between servers, and are not applied from the other layers.
+var fileOnlyTables = []string{"logging", "language_maps", "profiles"}
+
+// overridableKeys are the keys of fileOnlyTables that may still be set
+// from the other layers.
+var overridableKeys = []string{"logging.forward_level"}
+
+// checkOverrideKeys rejects overrides setting keys of fileOnlyTables.
+func checkOverrideKeys(overrides map[string]any) error {
+	var problems []client.ConfigProblem
+	for _, table := range fileOnlyTables {
+		value, ok := overrides[table]
+		if !ok {
+			continue
+		}
+
+		keys := []string{table}
+		if section, ok := value.(map[string]any); ok && table != "profiles" {
+			keys = nil
+			for _, key := range slices.Sorted(maps.Keys(section)) {
+				if key := table + "." + key; !slices.Contains(overridableKeys, key) {
+					keys = append(keys, key)
+				}
+			}
+		}
+
+		for _, key := range keys {
+			problems = append(problems, client.ConfigProblem{
+				Key:     key,
+				Message: "can only be set in config.toml or the environment",
+			})
+		}
+	}
+
+	if len(problems) > 0 {
+		return &client.ConfigError{Problems: problems}
+	}
+	return nil
+}
+
+// editorOverrides extracts config keys from editor settings. They may be
+// given at the top level or nested under the server name.
+func editorOverrides(settings any, section string) (map[string]any, error) {
+	if settings == nil {
+		return nil, nil
+	}
+
+	overrides, ok := settings.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("settings must be an object, got %T", settings)
+	}
+
+	if nested, ok := overrides[section]; ok {
+		overrides, ok = nested.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("settings.%s must be an object, got %T", section, nested)
+		}
+	}
+
+	return overrides, nil
+}
+
+// applyConfig pushes a new effective configuration to the running parts of
+// the server and refreshes the presence.
+func (h *LSPHandler) applyConfig(config *client.Config) {
+	idleAfter := parseDuration("lsp.idle_after", config.Lsp.IdleAfter, 5*time.Minute)
+	viewAfter := parseDuration("lsp.view_after", config.Lsp.ViewAfter, 5*time.Minute)
+	h.Presence.SetTimeouts(idleAfter, viewAfter)
+
+	client.SetUpdateInterval(parseDuration("discord.update_interval", config.Discord.UpdateInterval, 5*time.Second))
+	client.SetLogForwardLevel(config.Logging.ForwardLevel)
+
+	if h.Client.Editor != "" {
+		applicationID := h.applicationID(config)
+		if applicationID != h.Client.ApplicationID {
+			h.Client.ApplicationID = applicationID
+			client.Connect(applicationID, parseDuration("discord.retry_after", config.Discord.RetryAfter, time.Minute))
+		}
+	}
+
+	h.Presence.Refresh()
+}
+
+// applicationID returns the configured Discord application, or the one
+// matching the editor.
+func (h *LSPHandler) applicationID(config *client.Config) string {
+	if config.Discord.ApplicationID != "" {
+		return config.Discord.ApplicationID
+	}
+
+	switch h.Client.Editor {
+	case "neovim":
+		return "1352048301633044521" // Neovim
+	case "helix":
+		return "1351256971059396679" // Helix
+	default:
+		return "1351257618227920896" // Code
+	}
+}
+
+func parseDuration(key, value string, fallback time.Duration) time.Duration {
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		client.Error(fmt.Sprintf("Failed to parse %s duration, using %s", key, fallback), map[string]any{
+			"error": err,
+		})
+		return fallback
+	}
+	return duration
+}
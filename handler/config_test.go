@@ -0,0 +1,78 @@
+package handler
+
+import (
+	"testing"
+	"time"
+
+	"github.com/zerootoad/discord-rpc-lsp/client"
+)
+
+// newTestHandler returns a handler on the default config, without language
+// maps download nor Discord connection. Presence renders are captured.
+func newTestHandler(t *testing.T, langMaps client.LangMaps) (*LSPHandler, <-chan PresenceSnapshot) {
+	t.Helper()
+
+	config := client.DefaultConfig()
+	h := &LSPHandler{
+		Name:       "discord-rpc-lsp",
+		Client:     &client.Client{},
+		LangMaps:   &langMaps,
+		Workspaces: NewWorkspaces(),
+		fileConfig: config,
+		profile:    client.DefaultProfile,
+	}
+	h.config.Store(config)
+
+	presence, snapshots := startPresence(t, time.Hour, time.Hour)
+	h.Presence = presence
+
+	return h, snapshots
+}
+
+func TestEditorSettingsLayers(t *testing.T) {
+	h, _ := newTestHandler(t, client.LangMaps{})
+
+	err := h.setInitOptions(map[string]any{
+		"discord": map[string]any{"activity": map[string]any{"details": "Working on {workspace}"}},
+	})
+	if err != nil {
+		t.Fatalf("setInitOptions: %v", err)
+	}
+
+	err = h.setEditorSettings(map[string]any{
+		"discord-rpc-lsp": map[string]any{"lsp": map[string]any{"idle_after": "10m"}},
+	})
+	if err != nil {
+		t.Fatalf("setEditorSettings: %v", err)
+	}
+
+	config := h.Config()
+	if config.Discord.Activity.Details != "Working on {workspace}" {
+		t.Errorf("details = %q, want the initializationOptions to be kept", config.Discord.Activity.Details)
+	}
+	if config.Lsp.IdleAfter != "10m" {
+		t.Errorf("idle_after = %q, want %q", config.Lsp.IdleAfter, "10m")
+	}
+
+	// Workspace settings win over initializationOptions.
+	err = h.setEditorSettings(map[string]any{
+		"discord": map[string]any{"activity": map[string]any{"details": "From settings"}},
+	})
+	if err != nil {
+		t.Fatalf("setEditorSettings: %v", err)
+	}
+	if details := h.Config().Discord.Activity.Details; details != "From settings" {
+		t.Errorf("details = %q, want %q", details, "From settings")
+	}
+	if idleAfter := h.Config().Lsp.IdleAfter; idleAfter != client.DefaultConfig().Lsp.IdleAfter {
+		t.Errorf("idle_after = %q, want the previous settings to be replaced", idleAfter)
+	}
+
+	// Invalid settings leave the previous ones in place.
+	if err := h.setEditorSettings(map[string]any{"lsp": map[string]any{"idle_after": "soon"}}); err == nil {
+		t.Error("setEditorSettings accepted an invalid duration")
+	}
+	if details := h.Config().Discord.Activity.Details; details != "From settings" {
+		t.Errorf("details = %q after invalid settings, want %q", details, "From settings")
+	}
+}
@@ -0,0 +1,101 @@
+package handler
+
+import (
+	"time"
+
+	protocol "github.com/tliron/glsp/protocol_3_16"
+)
+
+type Document struct {
+	URI        protocol.DocumentUri
+	FileName   string
+	Language   string
+	OpenedAt   time.Time
+	LastEdit   time.Time
+	LastActive time.Time
+	Saves      int
+}
+
+// Documents is the registry of open documents keyed by URI. It is not safe
+// for concurrent use; it is owned by the presence goroutine.
+type Documents struct {
+	docs   map[protocol.DocumentUri]*Document
+	active protocol.DocumentUri
+}
+
+func NewDocuments() *Documents {
+	return &Documents{
+		docs: make(map[protocol.DocumentUri]*Document),
+	}
+}
+
+// Open registers the document, or refreshes it if already open, and makes it
+// the active one. An empty language keeps the one already known for the
+// document, or is "text" for a new one.
+func (d *Documents) Open(uri protocol.DocumentUri, fileName, language string) *Document {
+	now := time.Now()
+	doc, ok := d.docs[uri]
+	if !ok {
+		doc = &Document{URI: uri, OpenedAt: now, Language: "text"}
+		d.docs[uri] = doc
+	}
+	doc.FileName = fileName
+	if language != "" {
+		doc.Language = language
+	}
+	doc.LastActive = now
+	d.active = uri
+
+	return doc
+}
+
+// Edit marks the document as edited and active, registering it first if the
+// editor never sent didOpen for it.
+func (d *Documents) Edit(uri protocol.DocumentUri, fileName, language string) *Document {
+	doc := d.Open(uri, fileName, language)
+	doc.LastEdit = doc.LastActive
+
+	return doc
+}
+
+// Save counts a save of the document and makes it active.
+func (d *Documents) Save(uri protocol.DocumentUri, fileName, language string) *Document {
+	doc := d.Open(uri, fileName, language)
+	doc.Saves++
+
+	return doc
+}
+
+// Close removes the document. If it was the active one, the most recently
+// active remaining document becomes active and is returned.
+func (d *Documents) Close(uri protocol.DocumentUri) *Document {
+	delete(d.docs, uri)
+	if uri != d.active {
+		return d.Active()
+	}
+
+	d.active = ""
+	var next *Document
+	for _, doc := range d.docs {
+		if next == nil || doc.LastActive.After(next.LastActive) {
+			next = doc
+		}
+	}
+	if next != nil {
+		d.active = next.URI
+	}
+
+	return next
+}
+
+func (d *Documents) Get(uri protocol.DocumentUri) *Document {
+	return d.docs[uri]
+}
+
+func (d *Documents) Active() *Document {
+	return d.docs[d.active]
+}
+
+func (d *Documents) Len() int {
+	return len(d.docs)
+}
@@ -7,6 +7,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/tliron/glsp"
@@ -17,22 +18,30 @@ import (
 )
 
 type LSPHandler struct {
-	Name        string
-	Version     string
-	Handler     *protocol.Handler
-	Shutdown    bool
-	CurrentLang string
-	IdleTimer   *time.Timer
-	ViewTimer   *time.Timer
-	IdleAfter   time.Duration
-	ViewAfter   time.Duration
-	Client      *client.Client
-	LangMaps    *client.LangMaps
-	ElapsedTime *time.Time
-	Config      *client.Config
-	Mutex       sync.Mutex
-	IsIdle      bool
-	IsView      bool
+	Name       string
+	Version    string
+	Handler    *protocol.Handler
+	Shutdown   bool
+	Client     *client.Client
+	LangMaps   *client.LangMaps
+	Presence   *Presence
+	Workspaces *Workspaces
+	Mutex      sync.Mutex
+
+	// ProfileFile is where the active profile is remembered, if set.
+	ProfileFile string
+
+	config          atomic.Pointer[client.Config]
+	configMutex     sync.Mutex
+	fileConfig      *client.Config
+	projectSettings map[string]any
+	initOptions     map[string]any
+	editorSettings  map[string]any
+	profile         string
+
+	notify          glsp.NotifyFunc
+	notifyMutex     sync.Mutex
+	pendingMessages []*protocol.ShowMessageParams
 }
 
 func NewLSPHandler(name string, version string, config *client.Config) (*LSPHandler, error) {
@@ -53,73 +62,112 @@ func NewLSPHandler(name string, version string, config *client.Config) (*LSPHand
 		"url": config.LanguageMaps.URL,
 	})
 
-	idleAfter, err := time.ParseDuration(config.Lsp.IdleAfter)
-	if err != nil {
-		client.Error("Failed to parse timeout duration, using default 5m", map[string]any{
-			"error": err,
-		})
-		idleAfter = 5 * time.Minute
+	h := &LSPHandler{
+		Name:       name,
+		Version:    version,
+		Client:     &client.Client{},
+		LangMaps:   &langMaps,
+		Workspaces: NewWorkspaces(),
+		fileConfig: config,
+		profile:    client.DefaultProfile,
 	}
+	h.config.Store(config)
 
-	viewAfter, err := time.ParseDuration(config.Lsp.ViewAfter)
-	if err != nil {
-		client.Error("Failed to parse timeout duration, using default 5m", map[string]any{
-			"error": err,
-		})
-		viewAfter = 5 * time.Minute
-	}
-	return &LSPHandler{
-		Name:      name,
-		Version:   version,
-		Client:    &client.Client{},
-		LangMaps:  &langMaps,
-		IdleAfter: idleAfter,
-		ViewAfter: viewAfter,
-		Config:    config,
-	}, nil
+	idleAfter := parseDuration("lsp.idle_after", config.Lsp.IdleAfter, 5*time.Minute)
+	viewAfter := parseDuration("lsp.view_after", config.Lsp.ViewAfter, 5*time.Minute)
+	h.Presence = NewPresence(idleAfter, viewAfter, h.renderPresence)
+	h.Presence.Start()
+
+	client.SetLogForwardLevel(config.Logging.ForwardLevel)
+	client.SetLogForwarder(h.logMessage)
+	client.SetProblemReporter(h.reportProblem)
+	client.SetTracer(h.logTrace)
+
+	return h, nil
 }
 
-func (h *LSPHandler) ResetIdleTimer() {
-	if h.IdleTimer != nil {
-		h.IdleTimer.Stop()
+// renderPresence turns a presence snapshot into a Discord activity. It runs
+// on the presence goroutine.
+func (h *LSPHandler) renderPresence(snapshot PresenceSnapshot) {
+	config := h.Config()
+	workspace := h.workspaceFor(snapshot.URI)
+	extra := map[string]string{
+		"{open_files}": strconv.Itoa(snapshot.OpenFiles),
+		"{saves}":      strconv.Itoa(snapshot.Saves),
+		"{file_saves}": strconv.Itoa(snapshot.FileSaves),
+		"{line}":       "",
+		"{column}":     "",
 	}
-
-	if h.ElapsedTime == nil {
-		now := time.Now()
-		h.ElapsedTime = &now
-		h.IsIdle = false
+	if snapshot.HasLine {
+		extra["{line}"] = strconv.Itoa(utils.EvalOffset(fmt.Sprintf("%d%s", snapshot.Line, config.Lsp.LineOffset)))
+		extra["{column}"] = strconv.Itoa(snapshot.Column + 1)
 	}
 
-	h.IdleTimer = time.AfterFunc(h.IdleAfter, func() {
-		h.IsIdle = true
-		h.ElapsedTime = nil
+	client.Trace("Presence changed", map[string]any{
+		"state":     snapshot.State.String(),
+		"template":  actionTemplate(snapshot.State),
+		"fileName":  snapshot.FileName,
+		"language":  snapshot.Language,
+		"workspace": workspace.Name,
+	})
 
-		err := client.ClearDiscordActivity(h.Config, h.Config.Discord.Activity.IdleAction, "", h.Client.WorkspaceName, h.Client.Editor, h.Client.GitRemoteURL, h.Client.GitBranchName)
-		if err != nil {
-			client.Error("Failed to update Discord activity", map[string]any{
-				"error": err,
-			})
+	var err error
+	switch snapshot.State {
+	case StateIdle:
+		err = client.ClearDiscordActivity(config, config.Discord.Activity.IdleAction, "", workspace.Name, h.Client.Editor, workspace.GitRemoteURL, workspace.GitBranchName, snapshot.Elapsed, extra)
+	case StateNoFile:
+		err = client.UpdateDiscordActivity(config, "No file open", "", workspace.Name, "", h.Client.Editor, workspace.GitRemoteURL, workspace.GitBranchName, snapshot.Elapsed, extra)
+	case StateViewing:
+		err = client.UpdateDiscordActivity(config, config.Discord.Activity.ViewAction, snapshot.FileName, workspace.Name, snapshot.Language, h.Client.Editor, workspace.GitRemoteURL, workspace.GitBranchName, snapshot.Elapsed, extra)
+	case StateSaved:
+		err = client.UpdateDiscordActivity(config, config.Discord.Activity.SaveAction, snapshot.FileName, workspace.Name, snapshot.Language, h.Client.Editor, workspace.GitRemoteURL, workspace.GitBranchName, snapshot.Elapsed, extra)
+	case StateEditing:
+		action := config.Discord.Activity.EditAction
+		if snapshot.HasLine && config.Discord.Activity.EditingInfo {
+			action += " - In line " + extra["{line}"]
 		}
-		h.ElapsedTime = nil
-	})
-}
+		err = client.UpdateDiscordActivity(config, action, snapshot.FileName, workspace.Name, snapshot.Language, h.Client.Editor, workspace.GitRemoteURL, workspace.GitBranchName, snapshot.Elapsed, extra)
+	}
 
-func (h *LSPHandler) ResetViewTimer(filename string) {
-	if h.ViewTimer != nil {
-		h.ViewTimer.Stop()
+	if err != nil {
+		client.Error("Failed to update Discord activity", map[string]any{
+			"error": err,
+		})
 	}
+}
 
-	h.IsView = false
+// actionTemplate names the config key of the {action} template used in
+// state.
+func actionTemplate(state PresenceState) string {
+	switch state {
+	case StateIdle:
+		return "idle_action"
+	case StateViewing:
+		return "view_action"
+	case StateSaved:
+		return "save_action"
+	case StateEditing:
+		return "edit_action"
+	default:
+		return "none"
+	}
+}
 
-	h.ViewTimer = time.AfterFunc(h.ViewAfter, func() {
-		h.IsView = true
-		err := client.UpdateDiscordActivity(h.Config, h.Config.Discord.Activity.ViewAction, filename, h.Client.WorkspaceName, h.CurrentLang, h.Client.Editor, h.Client.GitRemoteURL, h.Client.GitBranchName, h.ElapsedTime)
-		if err != nil {
-			client.Error("Failed to update Discord activity", map[string]any{
-				"error": err,
-			})
+// workspaceFor returns the workspace folder containing uri, falling back to
+// the root workspace.
+func (h *LSPHandler) workspaceFor(uri protocol.DocumentUri) *Workspace {
+	if uri != "" {
+		if workspace := h.Workspaces.Find(uri); workspace != nil {
+			return workspace
 		}
-	})
+	}
+
+	return &Workspace{
+		URI:           protocol.DocumentUri(h.Client.RootURI),
+		Name:          h.Client.WorkspaceName,
+		GitRemoteURL:  h.Client.GitRemoteURL,
+		GitBranchName: h.Client.GitBranchName,
+	}
 }
 
 func (h *LSPHandler) NewServer() *server.Server {
@@ -134,6 +182,12 @@ func (h *LSPHandler) NewServer() *server.Server {
 		TextDocumentDidOpen:   h.didOpen,
 		TextDocumentDidChange: h.didChange,
 		TextDocumentDidClose:  h.didClose,
+		TextDocumentDidSave:   h.didSave,
+
+		// workspace notis
+		WorkspaceDidChangeWorkspaceFolders: h.didChangeWorkspaceFolders,
+		WorkspaceDidChangeConfiguration:    h.didChangeConfiguration,
+		WorkspaceExecuteCommand:            h.executeCommand,
 	}
 
 	return server.NewServer(h.Handler, h.Name, false)
@@ -148,41 +202,18 @@ func (h *LSPHandler) initialize(ctx *glsp.Context, params *protocol.InitializePa
 		return nil, fmt.Errorf("initialize params cannot be nil")
 	}
 
-	capabilities := h.Handler.CreateServerCapabilities()
-
-	h.Client.Editor = strings.ToLower(params.ClientInfo.Name)
-	h.Client.ApplicationID = ""
-	if h.Config.Discord.ApplicationID != "" {
-		h.Client.ApplicationID = h.Config.Discord.ApplicationID
-	} else {
-		switch h.Client.Editor {
-		case "neovim":
-			h.Client.ApplicationID = "1352048301633044521" // Neovim
-		case "helix":
-			h.Client.ApplicationID = "1351256971059396679" // Helix
-		default:
-			h.Client.ApplicationID = "1351257618227920896" // Code
-		}
+	if params.Trace != nil {
+		protocol.SetTraceValue(*params.Trace)
+		client.SetTrace(string(*params.Trace))
 	}
 
-	retryafter, err := time.ParseDuration(h.Config.Discord.RetryAfter)
-	if err != nil {
-		client.Error("Failed to parse retry_after duration, using 1 minute", map[string]any{
-			"error": err,
-		})
-		retryafter = 1 * time.Minute
-	}
-	for {
-		err := client.Login(string(h.Client.ApplicationID))
-		if err == nil {
-			break
+	if params.InitializationOptions != nil {
+		if err := h.setInitOptions(params.InitializationOptions); err != nil {
+			client.Error("Ignoring invalid initializationOptions", map[string]any{
+				"error": err,
+			})
+			h.reportConfigError("initializationOptions", err)
 		}
-
-		client.Error("Failed to create Discord RPC client, retrying in 1 minute", map[string]any{
-			"error": err,
-		})
-
-		time.Sleep(retryafter)
 	}
 
 	var rootURI string
@@ -206,6 +237,30 @@ func (h *LSPHandler) initialize(ctx *glsp.Context, params *protocol.InitializePa
 		"rootURI": h.Client.RootURI,
 	})
 
+	if !strings.Contains(rootURI, os.TempDir()) {
+		h.loadProjectConfig(utils.GetFilePath(rootURI))
+	}
+	config := h.Config()
+
+	// Editors send these on cursor movement once advertised, which is the
+	// only way to learn about switches between already open buffers.
+	if config.Lsp.TrackCursor {
+		h.Handler.TextDocumentDocumentHighlight = h.documentHighlight
+		h.Handler.TextDocumentHover = h.hover
+		h.Handler.TextDocumentCodeAction = h.codeAction
+	}
+
+	capabilities := h.Handler.CreateServerCapabilities()
+	capabilities.ExecuteCommandProvider.Commands = []string{ProfileCommand}
+
+	h.Client.Editor = strings.ToLower(params.ClientInfo.Name)
+	h.Client.ApplicationID = h.applicationID(config)
+
+	retryafter := parseDuration("discord.retry_after", config.Discord.RetryAfter, time.Minute)
+	client.SetUpdateInterval(parseDuration("discord.update_interval", config.Discord.UpdateInterval, 5*time.Second))
+
+	client.Connect(h.Client.ApplicationID, retryafter)
+
 	if !strings.Contains(rootURI, os.TempDir()) {
 		h.Client.WorkspaceName = utils.GetFileName(h.Client.RootURI)
 
@@ -227,6 +282,24 @@ func (h *LSPHandler) initialize(ctx *glsp.Context, params *protocol.InitializePa
 		h.Client.WorkspaceName = h.Client.Editor
 	}
 
+	client.SetLogFields(map[string]any{
+		"editor":    h.Client.Editor,
+		"workspace": h.Client.WorkspaceName,
+	})
+
+	// Documents outside every workspace folder fall back to the root above.
+	for _, folder := range params.WorkspaceFolders {
+		h.Workspaces.Add(folder.URI, folder.Name)
+	}
+
+	if capabilities.Workspace == nil {
+		capabilities.Workspace = &protocol.ServerCapabilitiesWorkspace{}
+	}
+	capabilities.Workspace.WorkspaceFolders = &protocol.WorkspaceFoldersServerCapabilities{
+		Supported:           &protocol.True,
+		ChangeNotifications: &protocol.BoolOrString{Value: true},
+	}
+
 	return protocol.InitializeResult{
 		Capabilities: capabilities,
 		ServerInfo: &protocol.InitializeResultServerInfo{
@@ -238,11 +311,16 @@ func (h *LSPHandler) initialize(ctx *glsp.Context, params *protocol.InitializePa
 
 func (h *LSPHandler) initialized(ctx *glsp.Context, params *protocol.InitializedParams) error {
 	client.Info("Initialized server", nil)
+	h.setNotify(ctx.Notify)
 	return nil
 }
 
 func (h *LSPHandler) setTrace(ctx *glsp.Context, params *protocol.SetTraceParams) error {
 	protocol.SetTraceValue(params.Value)
+	client.SetTrace(string(params.Value))
+	client.Info("Trace value set", map[string]any{
+		"trace": params.Value,
+	})
 	return nil
 }
 
@@ -252,6 +330,7 @@ func (h *LSPHandler) shutdown(ctx *glsp.Context) error {
 
 	h.Shutdown = true
 	client.Info("Shutdown request received", nil)
+	h.Presence.Stop()
 	client.Logout()
 
 	return nil
@@ -259,9 +338,7 @@ func (h *LSPHandler) shutdown(ctx *glsp.Context) error {
 
 func (h *LSPHandler) exit(ctx *glsp.Context) error {
 	client.Info("Exit notification received", nil)
-	if h.IdleTimer != nil {
-		h.IdleTimer.Stop()
-	}
+	h.Presence.Stop()
 	if h.Shutdown {
 		os.Exit(0)
 	} else {
@@ -271,129 +348,141 @@ func (h *LSPHandler) exit(ctx *glsp.Context) error {
 	return nil
 }
 
-func (h *LSPHandler) didOpen(ctx *glsp.Context, params *protocol.DidOpenTextDocumentParams) error {
-	h.Mutex.Lock()
-	defer h.Mutex.Unlock()
+func (h *LSPHandler) didChangeWorkspaceFolders(ctx *glsp.Context, params *protocol.DidChangeWorkspaceFoldersParams) error {
+	for _, folder := range params.Event.Removed {
+		h.Workspaces.Remove(folder.URI)
+	}
+	for _, folder := range params.Event.Added {
+		h.Workspaces.Add(folder.URI, folder.Name)
+	}
+
+	h.Presence.Refresh()
+
+	return nil
+}
+
+func (h *LSPHandler) didChangeConfiguration(ctx *glsp.Context, params *protocol.DidChangeConfigurationParams) error {
+	if err := h.setEditorSettings(params.Settings); err != nil {
+		client.Error("Ignoring invalid editor settings", map[string]any{
+			"error": err,
+		})
+		h.reportConfigError("editor settings", err)
+	}
+
+	return nil
+}
 
+func (h *LSPHandler) didOpen(ctx *glsp.Context, params *protocol.DidOpenTextDocumentParams) error {
 	fileName := utils.GetFileName(string(params.TextDocument.URI))
-	h.CurrentLang = h.LangMaps.GetLanguage(fileName)
-	if h.CurrentLang == "" {
-		h.CurrentLang = params.TextDocument.LanguageID
+	language := h.LangMaps.GetLanguage(fileName)
+	if language == "" {
+		language = params.TextDocument.LanguageID
 	}
 
 	client.Info("Opened file", map[string]any{
 		"fileName": fileName,
-		"language": h.CurrentLang,
-		"params":   params,
+		"language": language,
 	})
 
-	h.ResetIdleTimer()
+	// Document text is redacted unless logging.log_document_content is set.
+	client.Debug("Opened document content", map[string]any{
+		"document": params.TextDocument,
+	})
 
-	go func() {
-		err := client.UpdateDiscordActivity(h.Config, h.Config.Discord.Activity.ViewAction, fileName, h.Client.WorkspaceName, h.CurrentLang, h.Client.Editor, h.Client.GitRemoteURL, h.Client.GitBranchName, h.ElapsedTime)
-		if err != nil {
-			client.Error("Failed to update Discord activity", map[string]any{
-				"error": err,
-			})
-		}
-	}()
+	h.Presence.Open(params.TextDocument.URI, fileName, language)
 
 	return nil
 }
 
 func (h *LSPHandler) didClose(ctx *glsp.Context, params *protocol.DidCloseTextDocumentParams) error {
-	h.Mutex.Lock()
-	defer h.Mutex.Unlock()
-
 	fileName := utils.GetFileName(string(params.TextDocument.URI))
 
 	client.Info("File closed", map[string]any{
 		"fileName": fileName,
 	})
 
-	h.ResetIdleTimer()
+	h.Presence.Close(params.TextDocument.URI)
 
-	h.CurrentLang = ""
+	return nil
+}
 
-	go func() {
-		err := client.UpdateDiscordActivity(h.Config, "No file open", "", h.Client.WorkspaceName, "", h.Client.Editor, h.Client.GitRemoteURL, h.Client.GitBranchName, h.ElapsedTime)
-		if err != nil {
-			client.Error("Failed to update Discord activity", map[string]any{
-				"error": err,
-			})
-		}
-	}()
+func (h *LSPHandler) didSave(ctx *glsp.Context, params *protocol.DidSaveTextDocumentParams) error {
+	fileName := utils.GetFileName(string(params.TextDocument.URI))
+	// Empty if unknown, the language given on didOpen is kept then.
+	language := h.LangMaps.GetLanguage(fileName)
+
+	client.Info("Saved file", map[string]any{
+		"fileName": fileName,
+		"language": language,
+	})
+
+	h.Presence.Save(params.TextDocument.URI, fileName, language)
 
 	return nil
 }
 
 func (h *LSPHandler) didChange(ctx *glsp.Context, params *protocol.DidChangeTextDocumentParams) error {
-	h.Mutex.Lock()
-	defer h.Mutex.Unlock()
-
 	fileName := utils.GetFileName(string(params.TextDocument.URI))
-	h.CurrentLang = h.LangMaps.GetLanguage(fileName)
-	if h.CurrentLang == "" {
-		h.CurrentLang = "text"
-	}
+	// Empty if unknown, the language given on didOpen is kept then.
+	language := h.LangMaps.GetLanguage(fileName)
 
 	client.Info("Changed file", map[string]any{
 		"fileName": fileName,
-		"language": h.CurrentLang,
-		"params":   params,
+		"language": language,
 	})
 
-	h.ResetIdleTimer()
-	h.ResetViewTimer(fileName)
-
-	go func() {
-		if len(params.ContentChanges) > 0 {
-			change := params.ContentChanges[0]
-
-			switch change := change.(type) {
-			case protocol.TextDocumentContentChangeEvent:
-				var activity string
-				if change.Range != nil && h.Config.Discord.Activity.EditingInfo {
-					line := utils.EvalOffset(fmt.Sprintf("%d%s", change.Range.Start.Line, h.Config.Lsp.LineOffset))
-					activity = h.Config.Discord.Activity.EditAction + " - In line " + strconv.Itoa(line)
-				} else {
-					activity = h.Config.Discord.Activity.EditAction
-				}
-				err := client.UpdateDiscordActivity(h.Config, activity, fileName, h.Client.WorkspaceName, h.CurrentLang, h.Client.Editor, h.Client.GitRemoteURL, h.Client.GitBranchName, h.ElapsedTime)
-				if err != nil {
-					client.Error("Failed to update Discord activity", map[string]any{
-						"error": err,
-					})
-				}
-
-			case protocol.TextDocumentContentChangeEventWhole:
-				err := client.UpdateDiscordActivity(h.Config, h.Config.Discord.Activity.EditAction, fileName, h.Client.WorkspaceName, h.CurrentLang, h.Client.Editor, h.Client.GitRemoteURL, h.Client.GitBranchName, h.ElapsedTime)
-				if err != nil {
-					client.Error("Failed to update Discord activity", map[string]any{
-						"error": err,
-					})
-				}
-
-			default:
-				err := client.UpdateDiscordActivity(h.Config, h.Config.Discord.Activity.EditAction, fileName, h.Client.WorkspaceName, h.CurrentLang, h.Client.Editor, h.Client.GitRemoteURL, h.Client.GitBranchName, h.ElapsedTime)
-				client.Warn("Unknown content change type", map[string]any{
-					"changeType": fmt.Sprintf("%T", change),
-				})
-				if err != nil {
-					client.Error("Failed to update Discord activity", map[string]any{
-						"error": err,
-					})
-				}
-			}
-		} else {
-			err := client.UpdateDiscordActivity(h.Config, h.Config.Discord.Activity.EditAction, fileName, h.Client.WorkspaceName, h.CurrentLang, h.Client.Editor, h.Client.GitRemoteURL, h.Client.GitBranchName, h.ElapsedTime)
-			if err != nil {
-				client.Error("Failed to update Discord activity", map[string]any{
-					"error": err,
-				})
+	client.Debug("Changed document content", map[string]any{
+		"changes": params.ContentChanges,
+	})
+
+	var line, column int
+	var hasLine bool
+	if len(params.ContentChanges) > 0 {
+		switch change := params.ContentChanges[0].(type) {
+		case protocol.TextDocumentContentChangeEvent:
+			if change.Range != nil {
+				line = int(change.Range.Start.Line)
+				column = int(change.Range.Start.Character)
+				hasLine = true
 			}
+		case protocol.TextDocumentContentChangeEventWhole:
+		default:
+			client.Warn("Unknown content change type", map[string]any{
+				"changeType": fmt.Sprintf("%T", change),
+			})
 		}
-	}()
+	}
+
+	h.Presence.Change(params.TextDocument.URI, fileName, language, line, column, hasLine)
 
 	return nil
 }
+
+func (h *LSPHandler) documentHighlight(ctx *glsp.Context, params *protocol.DocumentHighlightParams) ([]protocol.DocumentHighlight, error) {
+	h.focus(params.TextDocument.URI, params.Position)
+	return nil, nil
+}
+
+func (h *LSPHandler) hover(ctx *glsp.Context, params *protocol.HoverParams) (*protocol.Hover, error) {
+	h.focus(params.TextDocument.URI, params.Position)
+	return nil, nil
+}
+
+func (h *LSPHandler) codeAction(ctx *glsp.Context, params *protocol.CodeActionParams) (any, error) {
+	h.focus(params.TextDocument.URI, params.Range.Start)
+	return nil, nil
+}
+
+func (h *LSPHandler) focus(uri protocol.DocumentUri, position protocol.Position) {
+	fileName := utils.GetFileName(string(uri))
+	// Empty if unknown, the language given on didOpen is kept then.
+	language := h.LangMaps.GetLanguage(fileName)
+
+	client.Debug("Cursor moved", map[string]any{
+		"fileName":  fileName,
+		"line":      position.Line,
+		"character": position.Character,
+	})
+
+	h.Presence.Focus(uri, fileName, language, int(position.Line), int(position.Character))
+}
@@ -0,0 +1,65 @@
+package handler
+
+import (
+	"testing"
+
+	protocol "github.com/tliron/glsp/protocol_3_16"
+	"github.com/zerootoad/discord-rpc-lsp/client"
+)
+
+func TestLanguageKeptWithoutMapping(t *testing.T) {
+	h, snapshots := newTestHandler(t, client.LangMaps{})
+	document := protocol.TextDocumentIdentifier{URI: "file:///build.zig"}
+
+	err := h.didOpen(nil, &protocol.DidOpenTextDocumentParams{
+		TextDocument: protocol.TextDocumentItem{URI: document.URI, LanguageID: "zig"},
+	})
+	if err != nil {
+		t.Fatalf("didOpen: %v", err)
+	}
+	if snapshot := nextSnapshot(t, snapshots); snapshot.Language != "zig" {
+		t.Fatalf("language after didOpen = %q, want %q", snapshot.Language, "zig")
+	}
+
+	steps := []struct {
+		name string
+		run  func() error
+	}{
+		{"didSave", func() error {
+			return h.didSave(nil, &protocol.DidSaveTextDocumentParams{TextDocument: document})
+		}},
+		{"didChange", func() error {
+			return h.didChange(nil, &protocol.DidChangeTextDocumentParams{
+				TextDocument: protocol.VersionedTextDocumentIdentifier{TextDocumentIdentifier: document},
+			})
+		}},
+		{"hover", func() error {
+			_, err := h.hover(nil, &protocol.HoverParams{
+				TextDocumentPositionParams: protocol.TextDocumentPositionParams{TextDocument: document},
+			})
+			return err
+		}},
+	}
+	for _, step := range steps {
+		if err := step.run(); err != nil {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+		if snapshot := nextSnapshot(t, snapshots); snapshot.Language != "zig" {
+			t.Errorf("language after %s = %q, want %q", step.name, snapshot.Language, "zig")
+		}
+	}
+}
+
+func TestLanguageFallback(t *testing.T) {
+	h, snapshots := newTestHandler(t, client.LangMaps{})
+
+	err := h.didSave(nil, &protocol.DidSaveTextDocumentParams{
+		TextDocument: protocol.TextDocumentIdentifier{URI: "file:///notes"},
+	})
+	if err != nil {
+		t.Fatalf("didSave: %v", err)
+	}
+	if snapshot := nextSnapshot(t, snapshots); snapshot.Language != "text" {
+		t.Errorf("language of a document never opened = %q, want %q", snapshot.Language, "text")
+	}
+}
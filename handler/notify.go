@@ -0,0 +1,116 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/rs/zerolog"
+	"github.com/tliron/glsp"
+	protocol "github.com/tliron/glsp/protocol_3_16"
+	"github.com/zerootoad/discord-rpc-lsp/client"
+)
+
+// setNotify stores the function used to notify the editor and sends the
+// messages held until then.
+func (h *LSPHandler) setNotify(notify glsp.NotifyFunc) {
+	h.notifyMutex.Lock()
+	defer h.notifyMutex.Unlock()
+
+	h.notify = notify
+	for _, params := range h.pendingMessages {
+		notify(protocol.ServerWindowShowMessage, params)
+	}
+	h.pendingMessages = nil
+}
+
+// showMessage sends window/showMessage to the editor. Messages sent before
+// the editor is initialized are held and sent once it is.
+func (h *LSPHandler) showMessage(messageType protocol.MessageType, message string) {
+	h.notifyMutex.Lock()
+	defer h.notifyMutex.Unlock()
+
+	params := &protocol.ShowMessageParams{
+		Type:    messageType,
+		Message: message,
+	}
+	if h.notify == nil {
+		h.pendingMessages = append(h.pendingMessages, params)
+		return
+	}
+	h.notify(protocol.ServerWindowShowMessage, params)
+}
+
+// logMessage sends window/logMessage to the editor. Log lines are dropped
+// until the editor is initialized.
+func (h *LSPHandler) logMessage(level zerolog.Level, message string) {
+	h.notifyMutex.Lock()
+	notify := h.notify
+	h.notifyMutex.Unlock()
+
+	if notify == nil {
+		return
+	}
+
+	messageType := protocol.MessageTypeLog
+	switch level {
+	case zerolog.ErrorLevel:
+		messageType = protocol.MessageTypeError
+	case zerolog.WarnLevel:
+		messageType = protocol.MessageTypeWarning
+	case zerolog.InfoLevel:
+		messageType = protocol.MessageTypeInfo
+	}
+
+	notify(protocol.ServerWindowLogMessage, &protocol.LogMessageParams{
+		Type:    messageType,
+		Message: message,
+	})
+}
+
+// logTrace sends $/logTrace to the editor. Traces are dropped until the
+// editor is initialized.
+func (h *LSPHandler) logTrace(message, verbose string) {
+	h.notifyMutex.Lock()
+	notify := h.notify
+	h.notifyMutex.Unlock()
+
+	if notify == nil {
+		return
+	}
+
+	params := &protocol.LogTraceParams{Message: message}
+	if verbose != "" {
+		params.Verbose = &verbose
+	}
+	notify(protocol.MethodLogTrace, params)
+}
+
+// reportProblem shows a problem the user has to act on.
+func (h *LSPHandler) reportProblem(message string) {
+	h.showMessage(protocol.MessageTypeWarning, fmt.Sprintf("%s: %s", h.Name, message))
+}
+
+// ReportConfigProblems shows the problems found in a config source to the
+// user.
+func (h *LSPHandler) ReportConfigProblems(source string, problems []client.ConfigProblem) {
+	if len(problems) == 0 {
+		return
+	}
+
+	lines := make([]string, len(problems))
+	for i, problem := range problems {
+		lines[i] = "- " + problem.String()
+	}
+	h.showMessage(protocol.MessageTypeError, fmt.Sprintf("%s: invalid config in %s\n%s", h.Name, source, strings.Join(lines, "\n")))
+}
+
+// reportConfigError shows why a config source was rejected.
+func (h *LSPHandler) reportConfigError(source string, err error) {
+	var configErr *client.ConfigError
+	if errors.As(err, &configErr) {
+		h.ReportConfigProblems(source, configErr.Problems)
+		return
+	}
+	h.showMessage(protocol.MessageTypeError, fmt.Sprintf("%s: invalid config in %s: %s", h.Name, source, err))
+}
@@ -0,0 +1,346 @@
+package handler
+
+import (
+	"time"
+
+	protocol "github.com/tliron/glsp/protocol_3_16"
+)
+
+type PresenceState int
+
+const (
+	StateNoFile PresenceState = iota
+	StateViewing
+	StateEditing
+	StateIdle
+	StateSaved
+)
+
+func (s PresenceState) String() string {
+	switch s {
+	case StateNoFile:
+		return "no_file"
+	case StateViewing:
+		return "viewing"
+	case StateEditing:
+		return "editing"
+	case StateIdle:
+		return "idle"
+	case StateSaved:
+		return "saved"
+	default:
+		return "unknown"
+	}
+}
+
+type presenceEventKind int
+
+const (
+	eventOpen presenceEventKind = iota
+	eventChange
+	eventClose
+	eventFocus
+	eventSave
+	eventViewTimeout
+	eventIdleTimeout
+	eventRefresh
+	eventTimeouts
+)
+
+type presenceEvent struct {
+	kind     presenceEventKind
+	uri      protocol.DocumentUri
+	fileName string
+	language string
+	line     int
+	column   int
+	hasLine  bool
+	gen      uint64
+
+	idleAfter time.Duration
+	viewAfter time.Duration
+}
+
+// PresenceSnapshot is what the presence machine hands to its renderer after
+// every transition. Elapsed is when the user started working, or when they
+// went idle while Idle.
+type PresenceSnapshot struct {
+	State     PresenceState
+	URI       protocol.DocumentUri
+	FileName  string
+	Language  string
+	Line      int
+	Column    int
+	HasLine   bool
+	Elapsed   *time.Time
+	OpenFiles int
+	FileSaves int
+	Saves     int
+}
+
+// Presence is the presence state machine. All state is owned by a single
+// goroutine and only changed in response to events, so timers firing late
+// cannot race with or undo newer transitions.
+//
+//	NoFile  --open-->   Viewing --change--> Editing --view_after--> Viewing
+//	any     --close of the current file--> Viewing the most recently active
+//	                                       open document, or NoFile
+//	any     --save--> Saved --view_after--> Viewing
+//	any     --focus on another file--> Viewing
+//	any     --idle_after--> Idle --open/change/focus--> Viewing/Editing
+type Presence struct {
+	render func(PresenceSnapshot)
+
+	events chan presenceEvent
+	done   chan struct{}
+
+	// owned by the run goroutine
+	idleAfter time.Duration
+	viewAfter time.Duration
+	documents *Documents
+	snapshot  PresenceSnapshot
+	idleTimer *time.Timer
+	viewTimer *time.Timer
+	idleGen   uint64
+	viewGen   uint64
+	rendered  bool
+}
+
+func NewPresence(idleAfter, viewAfter time.Duration, render func(PresenceSnapshot)) *Presence {
+	return &Presence{
+		idleAfter: idleAfter,
+		viewAfter: viewAfter,
+		render:    render,
+		events:    make(chan presenceEvent, 64),
+		done:      make(chan struct{}),
+		documents: NewDocuments(),
+		snapshot:  PresenceSnapshot{State: StateNoFile},
+	}
+}
+
+func (p *Presence) Start() {
+	go p.run()
+}
+
+// Stop terminates the state machine. Events sent afterwards are dropped.
+func (p *Presence) Stop() {
+	select {
+	case <-p.done:
+	default:
+		close(p.done)
+	}
+}
+
+func (p *Presence) Open(uri protocol.DocumentUri, fileName, language string) {
+	p.send(presenceEvent{kind: eventOpen, uri: uri, fileName: fileName, language: language})
+}
+
+func (p *Presence) Change(uri protocol.DocumentUri, fileName, language string, line, column int, hasLine bool) {
+	p.send(presenceEvent{kind: eventChange, uri: uri, fileName: fileName, language: language, line: line, column: column, hasLine: hasLine})
+}
+
+func (p *Presence) Save(uri protocol.DocumentUri, fileName, language string) {
+	p.send(presenceEvent{kind: eventSave, uri: uri, fileName: fileName, language: language})
+}
+
+// Focus reports the cursor position in a document, as inferred from
+// position-bearing requests such as hover or documentHighlight.
+func (p *Presence) Focus(uri protocol.DocumentUri, fileName, language string, line, column int) {
+	p.send(presenceEvent{kind: eventFocus, uri: uri, fileName: fileName, language: language, line: line, column: column})
+}
+
+func (p *Presence) Close(uri protocol.DocumentUri) {
+	p.send(presenceEvent{kind: eventClose, uri: uri})
+}
+
+// SetTimeouts changes idle_after and view_after. Running timers keep their
+// previous duration.
+func (p *Presence) SetTimeouts(idleAfter, viewAfter time.Duration) {
+	p.send(presenceEvent{kind: eventTimeouts, idleAfter: idleAfter, viewAfter: viewAfter})
+}
+
+// Refresh re-renders the current state without changing it. Nothing is
+// rendered before the first document event.
+func (p *Presence) Refresh() {
+	p.send(presenceEvent{kind: eventRefresh})
+}
+
+func (p *Presence) send(event presenceEvent) {
+	select {
+	case p.events <- event:
+	case <-p.done:
+	}
+}
+
+func (p *Presence) run() {
+	defer p.stopTimers()
+
+	for {
+		select {
+		case <-p.done:
+			return
+		case event := <-p.events:
+			if p.handle(event) {
+				p.render(p.snapshot)
+				p.rendered = true
+			}
+		}
+	}
+}
+
+// handle applies event to the current state and reports whether the state
+// changed and has to be rendered.
+func (p *Presence) handle(event presenceEvent) bool {
+	switch event.kind {
+	case eventOpen:
+		p.activity()
+		p.view(p.documents.Open(event.uri, event.fileName, event.language))
+		return true
+
+	case eventChange:
+		p.activity()
+		doc := p.documents.Edit(event.uri, event.fileName, event.language)
+		p.setDocument(doc)
+		p.snapshot.State = StateEditing
+		p.snapshot.Line = event.line
+		p.snapshot.Column = event.column
+		p.snapshot.HasLine = event.hasLine
+		p.resetViewTimer()
+		return true
+
+	case eventSave:
+		p.activity()
+		p.snapshot.Saves++
+		p.setDocument(p.documents.Save(event.uri, event.fileName, event.language))
+		p.snapshot.State = StateSaved
+		p.resetViewTimer()
+		return true
+
+	case eventFocus:
+		p.activity()
+		switched := event.uri != p.snapshot.URI || p.snapshot.State == StateIdle || p.snapshot.State == StateNoFile
+		doc := p.documents.Get(event.uri)
+		if switched || doc == nil {
+			p.view(p.documents.Open(event.uri, event.fileName, event.language))
+		} else {
+			doc.LastActive = time.Now()
+		}
+		p.snapshot.Line = event.line
+		p.snapshot.Column = event.column
+		p.snapshot.HasLine = true
+		return true
+
+	case eventClose:
+		if p.documents.Get(event.uri) == nil {
+			return false
+		}
+		p.activity()
+		next := p.documents.Close(event.uri)
+		p.snapshot.OpenFiles = p.documents.Len()
+		if event.uri != p.snapshot.URI && p.snapshot.State != StateIdle {
+			return true
+		}
+		if next != nil {
+			p.view(next)
+		} else {
+			p.setDocument(nil)
+			p.snapshot.State = StateNoFile
+			p.stopViewTimer()
+		}
+		return true
+
+	case eventViewTimeout:
+		if event.gen != p.viewGen || (p.snapshot.State != StateEditing && p.snapshot.State != StateSaved) {
+			return false
+		}
+		p.snapshot.State = StateViewing
+		p.snapshot.HasLine = false
+		return true
+
+	case eventIdleTimeout:
+		if event.gen != p.idleGen || p.snapshot.State == StateIdle {
+			return false
+		}
+		now := time.Now()
+		p.snapshot.State = StateIdle
+		p.snapshot.Elapsed = &now
+		p.stopViewTimer()
+		return true
+
+	case eventRefresh:
+		return p.rendered
+
+	case eventTimeouts:
+		p.idleAfter = event.idleAfter
+		p.viewAfter = event.viewAfter
+		return false
+	}
+
+	return false
+}
+
+// view switches to viewing doc.
+func (p *Presence) view(doc *Document) {
+	p.setDocument(doc)
+	p.snapshot.State = StateViewing
+	p.stopViewTimer()
+}
+
+func (p *Presence) setDocument(doc *Document) {
+	p.snapshot.HasLine = false
+	p.snapshot.OpenFiles = p.documents.Len()
+	if doc == nil {
+		p.snapshot.URI = ""
+		p.snapshot.FileName = ""
+		p.snapshot.Language = ""
+		p.snapshot.FileSaves = 0
+		return
+	}
+	p.snapshot.URI = doc.URI
+	p.snapshot.FileName = doc.FileName
+	p.snapshot.Language = doc.Language
+	p.snapshot.FileSaves = doc.Saves
+}
+
+// activity records user activity: it starts the elapsed time if needed, or
+// restarts it when leaving idle, and restarts the idle timer.
+func (p *Presence) activity() {
+	if p.snapshot.Elapsed == nil || p.snapshot.State == StateIdle {
+		now := time.Now()
+		p.snapshot.Elapsed = &now
+	}
+
+	if p.idleTimer != nil {
+		p.idleTimer.Stop()
+	}
+	p.idleGen++
+	gen := p.idleGen
+	p.idleTimer = time.AfterFunc(p.idleAfter, func() {
+		p.send(presenceEvent{kind: eventIdleTimeout, gen: gen})
+	})
+}
+
+func (p *Presence) resetViewTimer() {
+	p.stopViewTimer()
+	gen := p.viewGen
+	p.viewTimer = time.AfterFunc(p.viewAfter, func() {
+		p.send(presenceEvent{kind: eventViewTimeout, gen: gen})
+	})
+}
+
+// stopViewTimer stops the view timer and invalidates any timeout it may
+// already have queued.
+func (p *Presence) stopViewTimer() {
+	if p.viewTimer != nil {
+		p.viewTimer.Stop()
+		p.viewTimer = nil
+	}
+	p.viewGen++
+}
+
+func (p *Presence) stopTimers() {
+	p.stopViewTimer()
+	if p.idleTimer != nil {
+		p.idleTimer.Stop()
+	}
+}
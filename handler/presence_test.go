@@ -0,0 +1,138 @@
+package handler
+
+import (
+	"testing"
+	"time"
+)
+
+const (
+	testTimeout = 50 * time.Millisecond
+	// testWait is how long to wait for a render that has to happen.
+	testWait = 2 * time.Second
+)
+
+// startPresence runs a presence machine whose renders are captured.
+func startPresence(t *testing.T, idleAfter, viewAfter time.Duration) (*Presence, <-chan PresenceSnapshot) {
+	t.Helper()
+
+	snapshots := make(chan PresenceSnapshot, 64)
+	p := NewPresence(idleAfter, viewAfter, func(snapshot PresenceSnapshot) {
+		snapshots <- snapshot
+	})
+	p.Start()
+	t.Cleanup(p.Stop)
+
+	return p, snapshots
+}
+
+func nextSnapshot(t *testing.T, snapshots <-chan PresenceSnapshot) PresenceSnapshot {
+	t.Helper()
+
+	select {
+	case snapshot := <-snapshots:
+		return snapshot
+	case <-time.After(testWait):
+		t.Fatal("timed out waiting for a render")
+		return PresenceSnapshot{}
+	}
+}
+
+func expectState(t *testing.T, snapshots <-chan PresenceSnapshot, state PresenceState, fileName string) PresenceSnapshot {
+	t.Helper()
+
+	snapshot := nextSnapshot(t, snapshots)
+	if snapshot.State != state || snapshot.FileName != fileName {
+		t.Fatalf("rendered %s %q, want %s %q", snapshot.State, snapshot.FileName, state, fileName)
+	}
+	return snapshot
+}
+
+func expectNoRender(t *testing.T, snapshots <-chan PresenceSnapshot, wait time.Duration) {
+	t.Helper()
+
+	select {
+	case snapshot := <-snapshots:
+		t.Fatalf("unexpected render of %s %q", snapshot.State, snapshot.FileName)
+	case <-time.After(wait):
+	}
+}
+
+func TestPresenceOpenChangeView(t *testing.T) {
+	p, snapshots := startPresence(t, time.Hour, testTimeout)
+
+	p.Open("file:///a.go", "a.go", "go")
+	expectState(t, snapshots, StateViewing, "a.go")
+
+	p.Change("file:///a.go", "a.go", "go", 3, 4, true)
+	snapshot := expectState(t, snapshots, StateEditing, "a.go")
+	if !snapshot.HasLine || snapshot.Line != 3 || snapshot.Column != 4 {
+		t.Errorf("position = %d:%d (known %v), want 3:4", snapshot.Line, snapshot.Column, snapshot.HasLine)
+	}
+
+	// Back to viewing once view_after passes without changes.
+	snapshot = expectState(t, snapshots, StateViewing, "a.go")
+	if snapshot.HasLine {
+		t.Error("position still set after view_after")
+	}
+}
+
+func TestPresenceIdle(t *testing.T) {
+	p, snapshots := startPresence(t, testTimeout, time.Hour)
+
+	p.Open("file:///a.go", "a.go", "go")
+	viewing := expectState(t, snapshots, StateViewing, "a.go")
+
+	idle := expectState(t, snapshots, StateIdle, "a.go")
+	if idle.Elapsed == nil || !idle.Elapsed.After(*viewing.Elapsed) {
+		t.Errorf("idle elapsed = %v, want the time idling started", idle.Elapsed)
+	}
+
+	// Re-rendering while idle keeps the idle start time.
+	p.Refresh()
+	refreshed := expectState(t, snapshots, StateIdle, "a.go")
+	if !refreshed.Elapsed.Equal(*idle.Elapsed) {
+		t.Errorf("idle elapsed changed from %v to %v on refresh", idle.Elapsed, refreshed.Elapsed)
+	}
+
+	p.Change("file:///a.go", "a.go", "go", 0, 0, false)
+	editing := expectState(t, snapshots, StateEditing, "a.go")
+	if editing.Elapsed.Equal(*idle.Elapsed) {
+		t.Error("elapsed time not restarted after idling")
+	}
+}
+
+func TestPresenceCloseFallsBack(t *testing.T) {
+	p, snapshots := startPresence(t, time.Hour, time.Hour)
+
+	p.Open("file:///a.go", "a.go", "go")
+	expectState(t, snapshots, StateViewing, "a.go")
+	p.Open("file:///b.go", "b.go", "go")
+	expectState(t, snapshots, StateViewing, "b.go")
+
+	p.Close("file:///b.go")
+	snapshot := expectState(t, snapshots, StateViewing, "a.go")
+	if snapshot.OpenFiles != 1 {
+		t.Errorf("open files = %d, want 1", snapshot.OpenFiles)
+	}
+
+	p.Close("file:///a.go")
+	expectState(t, snapshots, StateNoFile, "")
+}
+
+func TestPresenceLateTimerAfterClose(t *testing.T) {
+	p, snapshots := startPresence(t, time.Hour, testTimeout)
+
+	p.Open("file:///a.go", "a.go", "go")
+	expectState(t, snapshots, StateViewing, "a.go")
+	p.Open("file:///b.go", "b.go", "go")
+	expectState(t, snapshots, StateViewing, "b.go")
+
+	// The view timer started by editing b.go must not render anything once
+	// b.go is closed and a.go shown instead.
+	p.Change("file:///b.go", "b.go", "go", 1, 0, true)
+	expectState(t, snapshots, StateEditing, "b.go")
+	p.Close("file:///b.go")
+	expectState(t, snapshots, StateViewing, "a.go")
+
+	expectNoRender(t, snapshots, 4*testTimeout)
+}
@@ -0,0 +1,105 @@
+package handler
+
+import (
+	"strings"
+	"sync"
+
+	protocol "github.com/tliron/glsp/protocol_3_16"
+	"github.com/zerootoad/discord-rpc-lsp/client"
+	"github.com/zerootoad/discord-rpc-lsp/utils"
+)
+
+type Workspace struct {
+	URI           protocol.DocumentUri
+	Name          string
+	GitRemoteURL  string
+	GitBranchName string
+}
+
+// Workspaces holds the workspace folders of the session. It is safe for
+// concurrent use.
+type Workspaces struct {
+	mu      sync.RWMutex
+	folders []*Workspace
+}
+
+func NewWorkspaces() *Workspaces {
+	return &Workspaces{}
+}
+
+// Add registers a workspace folder and looks up its git repository info.
+// The info is looked up even if git.git_info is off, so that enabling it
+// later shows it; whether it is displayed is decided when rendering.
+func (w *Workspaces) Add(uri protocol.DocumentUri, name string) *Workspace {
+	uri = protocol.DocumentUri(strings.TrimSuffix(string(uri), "/"))
+	if name == "" {
+		name = utils.GetFileName(string(uri))
+	}
+
+	workspace := &Workspace{URI: uri, Name: name}
+	remoteURL, branchName, err := client.GetGitRepositoryInfo(string(uri))
+	if err != nil {
+		client.Debug("No git repository info for workspace folder", map[string]any{
+			"uri":   uri,
+			"error": err,
+		})
+	} else {
+		workspace.GitRemoteURL = remoteURL
+		workspace.GitBranchName = branchName
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.removeLocked(uri)
+	w.folders = append(w.folders, workspace)
+
+	client.Info("Workspace folder added", map[string]any{
+		"uri":  uri,
+		"name": name,
+	})
+
+	return workspace
+}
+
+func (w *Workspaces) Remove(uri protocol.DocumentUri) {
+	uri = protocol.DocumentUri(strings.TrimSuffix(string(uri), "/"))
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.removeLocked(uri)
+
+	client.Info("Workspace folder removed", map[string]any{
+		"uri": uri,
+	})
+}
+
+func (w *Workspaces) removeLocked(uri protocol.DocumentUri) {
+	for i, folder := range w.folders {
+		if folder.URI == uri {
+			w.folders = append(w.folders[:i], w.folders[i+1:]...)
+			return
+		}
+	}
+}
+
+// Find returns the innermost workspace folder containing the document, or
+// nil if none does.
+func (w *Workspaces) Find(uri protocol.DocumentUri) *Workspace {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	var found *Workspace
+	for _, folder := range w.folders {
+		prefix := string(folder.URI) + "/"
+		if !strings.HasPrefix(string(uri), prefix) {
+			continue
+		}
+		if found == nil || len(folder.URI) > len(found.URI) {
+			found = folder
+		}
+	}
+
+	return found
+}
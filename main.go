@@ -1,40 +1,62 @@
 package main
 
 import (
+	"flag"
+	"fmt"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/zerootoad/discord-rpc-lsp/client"
 	"github.com/zerootoad/discord-rpc-lsp/handler"
-	"github.com/zerootoad/discord-rpc-lsp/utils"
 )
 
 func main() {
-	homedir := utils.GetUserHomeDir()
-	configDir := filepath.Join(homedir, ".discord-rpc-lsp")
+	configFlag := flag.String("config", "", "path to config.toml (default $"+client.ConfigEnvVar+", then the config directory)")
+	// Editors commonly pass --stdio, which is the only transport anyway.
+	flag.Bool("stdio", true, "communicate over stdin/stdout")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [--config path] [validate [path] | profile [name] | schema]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
 
-	err := os.MkdirAll(configDir, 0755)
-	if err != nil {
-		client.Error("Failed to create config directory", map[string]any{
-			"configDir": configDir,
-			"error":     err,
-		})
+	paths := client.ResolvePaths(*configFlag)
+	configFilePath := paths.ConfigFile
+	logFilePath := paths.LogFile
+
+	if flag.Arg(0) == "validate" {
+		if flag.NArg() > 1 {
+			configFilePath = flag.Arg(1)
+		}
+		os.Exit(validate(configFilePath))
+	}
+	if flag.Arg(0) == "schema" {
+		os.Exit(schema())
+	}
+	if flag.Arg(0) == "profile" {
+		os.Exit(profile(configFilePath, paths.ProfileFile, flag.Arg(1)))
 	}
 
-	logFilePath := filepath.Join(configDir, "lsp.log")
-	logFile, err := os.OpenFile(logFilePath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
-	if err != nil {
-		client.Error("Failed to open log file", map[string]any{
-			"logFilePath": logFilePath,
-			"error":       err,
-		})
+	// From here on the server owns stdout.
+	client.ReserveStdout()
+
+	for _, dir := range []string{filepath.Dir(configFilePath), filepath.Dir(logFilePath)} {
+		err := os.MkdirAll(dir, 0755)
+		if err != nil {
+			client.Error("Failed to create directory", map[string]any{
+				"dir":   dir,
+				"error": err,
+			})
+		}
 	}
-	defer logFile.Close()
 
-	client.InitLogger(logFilePath, "info", "stdout")
+	client.InitLogger(logFilePath, "info", "stderr", "console")
 	client.Debug("Starting app with default logger", nil)
 
-	configFilePath := filepath.Join(configDir, "config.toml")
 	config, err := client.LoadConfig(configFilePath)
 	if err != nil {
 		client.Error("Failed to load or create configuration", map[string]any{
@@ -43,12 +65,16 @@ func main() {
 		})
 		config = client.DefaultConfig()
 	}
+	config, envKeys := client.ApplyEnvOverrides(config)
 
-	client.InitLogger(logFilePath, config.Logging.Level, config.Logging.Output)
+	client.SetLogRotation(config.LogRotation())
+	client.InitLogger(logFilePath, config.Logging.Level, config.Logging.Output, config.Logging.Format)
+	client.SetLogDocumentContent(config.Logging.LogDocumentContent)
 	client.Debug("Logger re-initialized with user config", map[string]any{
 		"level":  config.Logging.Level,
 		"output": config.Logging.Output,
 	})
+	client.LogEnvOverrides(envKeys)
 
 	lspHandler, err := handler.NewLSPHandler("discord-rpc-lsp", "1.0.1", config)
 	if err != nil {
@@ -57,6 +83,23 @@ func main() {
 		})
 	}
 
+	problems, err := client.CheckConfigFile(configFilePath)
+	if err == nil {
+		lspHandler.ReportConfigProblems(configFilePath, problems)
+	}
+
+	lspHandler.ProfileFile = paths.ProfileFile
+	loadProfile(lspHandler, paths.ProfileFile)
+
+	go client.WatchConfig(configFilePath, 2*time.Second, nil, func() {
+		reloadConfig(lspHandler, configFilePath, logFilePath)
+	})
+	// Picks up profiles switched from the command line or other editors.
+	go client.WatchConfig(paths.ProfileFile, 2*time.Second, nil, func() {
+		loadProfile(lspHandler, paths.ProfileFile)
+	})
+	go reloadOnSignal(lspHandler, configFilePath, logFilePath)
+
 	server := lspHandler.NewServer()
 	client.Debug("Starting LSP server", map[string]any{})
 	err = server.RunStdio()
@@ -67,3 +110,142 @@ func main() {
 	}
 
 }
+
+// reloadOnSignal reloads config.toml whenever the process receives SIGHUP.
+func reloadOnSignal(lspHandler *handler.LSPHandler, configFilePath, logFilePath string) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+
+	for range signals {
+		client.Info("Received SIGHUP, reloading config", map[string]any{
+			"filepath": configFilePath,
+		})
+		reloadConfig(lspHandler, configFilePath, logFilePath)
+	}
+}
+
+// reloadConfig reads config.toml again and swaps it in. If the file is gone
+// or invalid, the current config is kept.
+func reloadConfig(lspHandler *handler.LSPHandler, configFilePath, logFilePath string) {
+	if _, err := os.Stat(configFilePath); err != nil {
+		client.Error("Failed to reload config, keeping the current one", map[string]any{
+			"configFilePath": configFilePath,
+			"error":          err,
+		})
+		return
+	}
+
+	problems, err := client.CheckConfigFile(configFilePath)
+	if err == nil && len(problems) > 0 {
+		err = &client.ConfigError{Problems: problems}
+		lspHandler.ReportConfigProblems(configFilePath, problems)
+	}
+	if err != nil {
+		client.Error("Failed to reload config, keeping the current one", map[string]any{
+			"configFilePath": configFilePath,
+			"error":          err,
+		})
+		return
+	}
+
+	config, err := client.ReloadConfig(configFilePath)
+	if err != nil {
+		client.Error("Failed to reload config, keeping the current one", map[string]any{
+			"configFilePath": configFilePath,
+			"error":          err,
+		})
+		return
+	}
+	config, envKeys := client.ApplyEnvOverrides(config)
+
+	client.SetLogRotation(config.LogRotation())
+	client.InitLogger(logFilePath, config.Logging.Level, config.Logging.Output, config.Logging.Format)
+	client.SetLogDocumentContent(config.Logging.LogDocumentContent)
+	client.LogEnvOverrides(envKeys)
+	lspHandler.SetFileConfig(config)
+
+	client.Info("Reloaded config", map[string]any{
+		"configFilePath": configFilePath,
+	})
+}
+
+// loadProfile activates the profile stored in the profile file.
+func loadProfile(lspHandler *handler.LSPHandler, profileFilePath string) {
+	name := client.LoadActiveProfile(profileFilePath)
+	if err := lspHandler.SetProfile(name); err != nil {
+		client.Warn("Failed to activate stored profile", map[string]any{
+			"profile": name,
+			"error":   err,
+		})
+	}
+}
+
+// profile prints the available profiles, or switches the running servers
+// to the named one. It returns the process exit code.
+func profile(configFilePath, profileFilePath, name string) int {
+	config, err := client.ReadConfig(configFilePath)
+	if os.IsNotExist(err) {
+		config, err = client.DefaultConfig(), nil
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", configFilePath, err)
+		return 2
+	}
+
+	if name == "" {
+		active := client.LoadActiveProfile(profileFilePath)
+		for _, name := range append([]string{client.DefaultProfile}, config.ProfileNames()...) {
+			marker := " "
+			if name == active {
+				marker = "*"
+			}
+			fmt.Printf("%s %s\n", marker, name)
+		}
+		return 0
+	}
+
+	if !config.HasProfile(name) {
+		fmt.Fprintf(os.Stderr, "unknown profile %q, available: %s\n", name, strings.Join(append([]string{client.DefaultProfile}, config.ProfileNames()...), ", "))
+		return 1
+	}
+	if err := client.SaveActiveProfile(profileFilePath, name); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", profileFilePath, err)
+		return 2
+	}
+
+	fmt.Printf("Switched to profile %s\n", name)
+	return 0
+}
+
+// schema prints the JSON Schema of config.toml. It returns the process exit
+// code.
+func schema() int {
+	data, err := client.ConfigSchema()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to generate schema: %v\n", err)
+		return 2
+	}
+
+	fmt.Println(string(data))
+	return 0
+}
+
+// validate checks a config file and prints every problem found. It returns
+// the process exit code.
+func validate(configFilePath string) int {
+	problems, err := client.CheckConfigFile(configFilePath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", configFilePath, err)
+		return 2
+	}
+
+	for _, problem := range problems {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", configFilePath, problem)
+	}
+	if len(problems) > 0 {
+		return 1
+	}
+
+	fmt.Printf("%s: ok\n", configFilePath)
+	return 0
+}
@@ -1,6 +1,7 @@
 package utils
 
 import (
+	"net/url"
 	"os"
 	"path/filepath"
 	"runtime"
@@ -10,29 +11,94 @@ import (
 	"time"
 )
 
-type Throttler struct {
+// Coalescer delivers the most recently submitted function on the trailing
+// edge of an interval. Functions submitted while one is already scheduled
+// replace it, so the latest state is always run once the interval expires.
+// At most limit runs happen within any window.
+type Coalescer struct {
 	interval time.Duration
-	lastRun  time.Time
+	limit    int
+	window   time.Duration
+	pending  func()
+	timer    *time.Timer
+	runs     []time.Time
 	mu       sync.Mutex
 }
 
-func NewThrottler(interval time.Duration) *Throttler {
-	return &Throttler{
+func NewCoalescer(interval time.Duration, limit int, window time.Duration) *Coalescer {
+	return &Coalescer{
 		interval: interval,
+		limit:    limit,
+		window:   window,
 	}
 }
 
-func (t *Throttler) Run(f func()) {
-	t.mu.Lock()
-	defer t.mu.Unlock()
+func (c *Coalescer) SetInterval(interval time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 
-	now := time.Now()
-	if now.Sub(t.lastRun) < t.interval {
+	c.interval = interval
+}
+
+func (c *Coalescer) Run(f func()) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.pending = f
+	if c.timer != nil {
 		return
 	}
 
-	t.lastRun = now
-	f()
+	delay := max(time.Until(c.nextRun()), 0)
+	c.timer = time.AfterFunc(delay, c.fire)
+}
+
+// Stop drops the pending function, if any.
+func (c *Coalescer) Stop() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.timer != nil {
+		c.timer.Stop()
+		c.timer = nil
+	}
+	c.pending = nil
+}
+
+func (c *Coalescer) fire() {
+	c.mu.Lock()
+	f := c.pending
+	c.pending = nil
+	c.timer = nil
+	if f != nil {
+		c.runs = append(c.runs, time.Now())
+		// The last run is kept for the interval even without limit.
+		if keep := max(c.limit, 1); len(c.runs) > keep {
+			c.runs = c.runs[len(c.runs)-keep:]
+		}
+	}
+	c.mu.Unlock()
+
+	if f != nil {
+		f()
+	}
+}
+
+// nextRun returns the earliest time the next function may run. c.mu must be
+// held.
+func (c *Coalescer) nextRun() time.Time {
+	if len(c.runs) == 0 {
+		return time.Time{}
+	}
+
+	next := c.runs[len(c.runs)-1].Add(c.interval)
+	if c.limit > 0 && len(c.runs) >= c.limit {
+		windowStart := c.runs[len(c.runs)-c.limit].Add(c.window)
+		if windowStart.After(next) {
+			next = windowStart
+		}
+	}
+	return next
 }
 
 func GetUserHomeDir() string {
@@ -55,6 +121,18 @@ func GetFileName(uri string) string {
 	return filepath.Base(uri)
 }
 
+// GetFilePath converts a file:// URI to a local path.
+func GetFilePath(uri string) string {
+	if parsed, err := url.Parse(uri); err == nil && parsed.Scheme == "file" {
+		path := parsed.Path
+		if runtime.GOOS == "windows" {
+			path = strings.TrimPrefix(path, "/")
+		}
+		return filepath.FromSlash(path)
+	}
+	return uri
+}
+
 func GetFileExtension(uri string) string {
 	return filepath.Ext(uri)
 }
@@ -0,0 +1,99 @@
+package utils
+
+import (
+	"testing"
+	"time"
+)
+
+const testInterval = 50 * time.Millisecond
+
+// collect returns a function making runs that send their value on the
+// returned channel.
+func collect() (func(int) func(), <-chan int) {
+	runs := make(chan int, 16)
+	return func(value int) func() {
+		return func() { runs <- value }
+	}, runs
+}
+
+func nextRun(t *testing.T, runs <-chan int) int {
+	t.Helper()
+
+	select {
+	case value := <-runs:
+		return value
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for a run")
+		return 0
+	}
+}
+
+func expectNoRun(t *testing.T, runs <-chan int, wait time.Duration) {
+	t.Helper()
+
+	select {
+	case value := <-runs:
+		t.Fatalf("unexpected run of %d", value)
+	case <-time.After(wait):
+	}
+}
+
+func TestCoalescerRunsLatest(t *testing.T) {
+	c := NewCoalescer(testInterval, 0, 0)
+	defer c.Stop()
+	run, runs := collect()
+
+	// The first run is not delayed.
+	c.Run(run(1))
+	if value := nextRun(t, runs); value != 1 {
+		t.Fatalf("ran %d, want 1", value)
+	}
+
+	// Runs submitted within the interval are coalesced into the latest one,
+	// on the trailing edge.
+	start := time.Now()
+	c.Run(run(2))
+	c.Run(run(3))
+	if value := nextRun(t, runs); value != 3 {
+		t.Errorf("ran %d, want the latest 3", value)
+	}
+	if elapsed := time.Since(start); elapsed < testInterval/2 {
+		t.Errorf("ran after %s, want about %s", elapsed, testInterval)
+	}
+	expectNoRun(t, runs, 2*testInterval)
+}
+
+func TestCoalescerStop(t *testing.T) {
+	c := NewCoalescer(testInterval, 0, 0)
+	run, runs := collect()
+
+	c.Run(run(1))
+	nextRun(t, runs)
+
+	c.Run(run(2))
+	c.Stop()
+	expectNoRun(t, runs, 2*testInterval)
+}
+
+func TestCoalescerLimit(t *testing.T) {
+	window := 10 * testInterval
+	c := NewCoalescer(time.Millisecond, 2, window)
+	defer c.Stop()
+	run, runs := collect()
+
+	start := time.Now()
+	c.Run(run(1))
+	nextRun(t, runs)
+	time.Sleep(5 * time.Millisecond)
+	c.Run(run(2))
+	nextRun(t, runs)
+
+	// A third run within the window waits for the first to leave it.
+	c.Run(run(3))
+	if value := nextRun(t, runs); value != 3 {
+		t.Errorf("ran %d, want 3", value)
+	}
+	if elapsed := time.Since(start); elapsed < window {
+		t.Errorf("third run after %s, want at least %s", elapsed, window)
+	}
+}